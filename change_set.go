@@ -0,0 +1,389 @@
+package redconf
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+)
+
+type OnChangeSetSubscriber func(changeSet ChangeSet)
+
+// ChangeSet is the values changed together, such as the keys in one batch message,
+// the keys changed by one file edit, or the drifted keys found by the resync,
+// the keys of one batch message are applied all or nothing, the values of resync are
+// applied struct by struct, or key by key while the struct failed, and the Events
+// are sorted by key
+type ChangeSet struct {
+	Namespace  string
+	Events     []OnValueChangedEvent
+	UpdateTime time.Time
+	Resync     bool
+}
+
+func (p ChangeSet) Keys() []string {
+	var keys []string
+
+	for _, event := range p.Events {
+		keys = append(keys, event.Key)
+	}
+
+	return keys
+}
+
+// fieldChange is the new value of a watching key in the change set
+type fieldChange struct {
+	field *Field
+	raw   interface{}
+	event OnValueChangedEvent
+}
+
+// events return the event of the change, the change of map field in keys or hash mode
+// is split into the events of the changed entries
+func (p *fieldChange) events() []OnValueChangedEvent {
+	if p.field.convOptions.mapMode == "" {
+		return []OnValueChangedEvent{p.event}
+	}
+
+	return mapEntryEvents(p.event)
+}
+
+// changeResult is collected under the lock of RedConf, and published after unlocked,
+// so the subscribers could call the methods of RedConf
+type changeResult struct {
+	changeSet ChangeSet
+	errors    []ErrorEvent
+	hooks     []configHook
+}
+
+// reject report the rejected change to SubscribeErrors only, the value subscribers
+// never see the rejected value
+func (p *changeResult) reject(change *fieldChange, err error) {
+
+	stage := ErrorStageConvert
+
+	if _, ok := err.(*validationError); ok {
+		stage = ErrorStageValidate
+	}
+
+	p.errors = append(p.errors, ErrorEvent{
+		Namespace: change.event.Namespace,
+		Key:       change.event.Key,
+		Value:     change.raw,
+		Stage:     stage,
+		Error:     err,
+		Time:      change.event.UpdateTime,
+	})
+}
+
+// changeGroup is the changes of the keys which are converted and validated together,
+// it is not applied until commitChanges
+type changeGroup struct {
+	confs    []*WatchingConfig
+	changes  map[*WatchingConfig][]*fieldChange
+	failures []changeFailure
+}
+
+type changeFailure struct {
+	change *fieldChange
+	err    error
+}
+
+func (p *changeResult) rejectGroup(namespace string, group *changeGroup, err error) {
+
+	failed := make(map[*fieldChange]bool)
+
+	for _, failure := range group.failures {
+		p.reject(failure.change, failure.err)
+		failed[failure.change] = true
+	}
+
+	rejectedErr := fmt.Errorf("redconf: the change set in namespace %s is rejected, %s", namespace, err)
+
+	// keep the stage of the failure which rejected the change set
+	if _, ok := err.(*validationError); ok {
+		rejectedErr = &validationError{rejectedErr}
+	}
+
+	for _, conf := range group.confs {
+		for _, change := range group.changes[conf] {
+			if !failed[change] {
+				p.reject(change, rejectedErr)
+			}
+		}
+	}
+}
+
+// applyChanges apply the change sets, every change set is the keys of one batch message,
+// it is applied all or nothing, so the app never see a part of it, the change sets
+// merged by the coalesce window are still validated one by one.
+// it should be called with the confLock held
+func (p *RedConf) applyChanges(kvs map[string]interface{}, changeSets [][]string) (result *changeResult) {
+
+	result = p.newChangeResult(false)
+
+	for _, keys := range changeSets {
+		group, err := p.prepareChanges(kvs, keys, result.changeSet.UpdateTime, false)
+		if err != nil {
+			result.rejectGroup(p.namespace, group, err)
+			continue
+		}
+
+		p.commitChanges(result, group)
+	}
+
+	result.sortEvents()
+
+	return
+}
+
+// applyValues apply the values of resync, the values are not changed together, so they
+// are applied struct by struct, while the values of a struct are failed, they are applied
+// key by key, so one bad key will not block the others.
+// it should be called with the confLock held
+func (p *RedConf) applyValues(kvs map[string]interface{}) (result *changeResult) {
+
+	result = p.newChangeResult(true)
+
+	confs, confKeys := p.groupKeys(kvs)
+
+	for _, conf := range confs {
+		keys := confKeys[conf]
+
+		group, err := p.prepareChanges(kvs, keys, result.changeSet.UpdateTime, true)
+		if err == nil {
+			p.commitChanges(result, group)
+			continue
+		}
+
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			if group, err = p.prepareChanges(kvs, []string{key}, result.changeSet.UpdateTime, true); err != nil {
+				result.rejectGroup(p.namespace, group, err)
+				continue
+			}
+
+			p.commitChanges(result, group)
+		}
+	}
+
+	result.sortEvents()
+
+	return
+}
+
+// prepareValues prepare the values of the initial sync struct by struct, it is failed
+// while any struct is failed, so the watching structs are not changed by a failed watch.
+// it should be called with the confLock held
+func (p *RedConf) prepareValues(kvs map[string]interface{}, now time.Time) (groups []*changeGroup, err error) {
+
+	confs, confKeys := p.groupKeys(kvs)
+
+	for _, conf := range confs {
+		var group *changeGroup
+		if group, err = p.prepareChanges(kvs, confKeys[conf], now, false); err != nil {
+			groups = nil
+			return
+		}
+
+		groups = append(groups, group)
+	}
+
+	return
+}
+
+// groupKeys group the watching keys by the structs, sorted by the config names
+func (p *RedConf) groupKeys(kvs map[string]interface{}) (confs []*WatchingConfig, confKeys map[*WatchingConfig][]string) {
+
+	confKeys = make(map[*WatchingConfig][]string)
+
+	for key := range kvs {
+		if field, exist := p.watchingKeyIndex[key]; exist {
+			if _, exist = confKeys[field.conf]; !exist {
+				confs = append(confs, field.conf)
+			}
+			confKeys[field.conf] = append(confKeys[field.conf], key)
+		}
+	}
+
+	sort.Slice(confs, func(i, j int) bool {
+		return confs[i].name < confs[j].name
+	})
+
+	return
+}
+
+func (p *RedConf) newChangeResult(resync bool) *changeResult {
+	return &changeResult{
+		changeSet: ChangeSet{
+			Namespace:  p.namespace,
+			UpdateTime: time.Now(),
+			Resync:     resync,
+		},
+	}
+}
+
+func (p *changeResult) sortEvents() {
+	sort.Slice(p.changeSet.Events, func(i, j int) bool {
+		return p.changeSet.Events[i].Key < p.changeSet.Events[j].Key
+	})
+}
+
+// prepareChanges conv and validate the values of keys, any failure fails the whole group
+func (p *RedConf) prepareChanges(kvs map[string]interface{}, keys []string, now time.Time, resync bool) (group *changeGroup, err error) {
+
+	group = &changeGroup{
+		changes: make(map[*WatchingConfig][]*fieldChange),
+	}
+
+	keys = append([]string(nil), keys...)
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		field, exist := p.watchingKeyIndex[key]
+		if !exist {
+			continue
+		}
+
+		change := &fieldChange{
+			field: field,
+			raw:   kvs[key],
+			event: OnValueChangedEvent{
+				Namespace:   p.namespace,
+				Key:         key,
+				BeforeValue: field.Value(),
+				UpdateTime:  now,
+				Resync:      resync,
+			},
+		}
+
+		newVal, e := p.fieldValue(field, change.raw)
+		if e != nil {
+			group.failures = append(group.failures, changeFailure{change, e})
+			if err == nil {
+				err = e
+			}
+			continue
+		}
+
+		if valueEqual(change.event.BeforeValue, newVal) {
+			continue
+		}
+
+		change.event.AfterValue = newVal
+
+		if _, exist := group.changes[field.conf]; !exist {
+			group.confs = append(group.confs, field.conf)
+		}
+		group.changes[field.conf] = append(group.changes[field.conf], change)
+	}
+
+	if err != nil {
+		return
+	}
+
+	// validate the structs with all the changes, the fields may depend on each other
+	for _, conf := range group.confs {
+		if e := p.validateConfig(conf, group.changes[conf]); e != nil {
+			for _, change := range group.changes[conf] {
+				group.failures = append(group.failures, changeFailure{change, e})
+			}
+			err = e
+			return
+		}
+	}
+
+	return
+}
+
+// commitChanges apply the prepared changes and collect the events and hooks
+func (p *RedConf) commitChanges(result *changeResult, group *changeGroup) {
+	for _, conf := range group.confs {
+		conf.apply(group.changes[conf])
+		result.hooks = append(result.hooks, collectHooks(conf, group.changes[conf])...)
+		for _, change := range group.changes[conf] {
+			result.changeSet.Events = append(result.changeSet.Events, change.events()...)
+		}
+	}
+}
+
+// validateConfig validate a copy of the watching struct with the changes
+// while the watching struct implemented Validator
+func (p *RedConf) validateConfig(conf *WatchingConfig, changes []*fieldChange) (err error) {
+
+	if _, ok := conf.value.(Validator); !ok {
+		return
+	}
+
+	cp := conf.copyValue()
+
+	for _, change := range changes {
+		change.field.setOn(cp, change.event.AfterValue, true)
+	}
+
+	if err = cp.Interface().(Validator).Validate(); err != nil {
+		err = &validationError{fmt.Errorf("redconf: validate config %s in namespace %s failure, %s", conf.name, p.namespace, err)}
+		return
+	}
+
+	return
+}
+
+// fieldValue conv the value from storage to the type of field, and validate it by the constraints of tag
+func (p *RedConf) fieldValue(field *Field, value interface{}) (newVal interface{}, err error) {
+
+	if value == nil {
+		if defaultValue, hasDefault := field.Default(); hasDefault {
+			value = defaultValue
+		} else if field.Required() {
+			err = &validationError{fmt.Errorf("redconf: the required key of %s in namespace %s is missing", field.String(), p.namespace)}
+			return
+		}
+	}
+
+	if newVal, err = conv(field.Type(), value, field.convOptions); err != nil {
+		err = fmt.Errorf("redconf: conv value of key %s in namespace %s failure, %s", field.String(), p.namespace, err)
+		return
+	}
+
+	if newVal == nil {
+		newVal = reflect.Zero(field.Type()).Interface()
+	}
+
+	// the missing key without default is not validated, use required for it
+	if value == nil {
+		return
+	}
+
+	if err = field.validate(newVal); err != nil {
+		err = &validationError{fmt.Errorf("redconf: validate key of %s in namespace %s failure, %s", field.String(), p.namespace, err)}
+		return
+	}
+
+	return
+}
+
+type validationError struct {
+	error
+}
+
+// valueEqual compare the pointers by the values they point to, and the times by Equal,
+// the same instant may have different locations, the other values are compared deeply,
+// the values which print the same, such as ["a b"] and ["a","b"], are still different
+func valueEqual(a, b interface{}) bool {
+
+	va := reflect.Indirect(reflect.ValueOf(a))
+	vb := reflect.Indirect(reflect.ValueOf(b))
+
+	if !va.IsValid() || !vb.IsValid() {
+		return va.IsValid() == vb.IsValid()
+	}
+
+	if ta, ok := va.Interface().(time.Time); ok {
+		tb, ok := vb.Interface().(time.Time)
+		return ok && ta.Equal(tb)
+	}
+
+	return reflect.DeepEqual(va.Interface(), vb.Interface())
+}
@@ -9,6 +9,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
@@ -50,10 +51,29 @@ func main() {
 			Usage: "Which redis channel to publish value changed event",
 			Value: "REDCONF:ONCHANGED",
 		},
+		cli.StringFlag{
+			Name:  "stream",
+			Usage: "Which redis stream to add value changed event",
+			Value: "REDCONF:STREAM",
+		},
+		cli.IntFlag{
+			Name:  "stream-maxlen",
+			Usage: "Trim the redis-stream to about this length while adding the changed event, 0 is not trimmed",
+			Value: 0,
+		},
 		cli.BoolFlag{
 			Name:  "notify",
 			Usage: "Publish changed event to redis-channel",
 		},
+		cli.BoolFlag{
+			Name:  "batch",
+			Usage: "Notify the changed keys by one message of JSON array, they are applied together, it requires the monitors which could parse the batch message",
+		},
+		cli.StringFlag{
+			Name:  "notify-mode",
+			Usage: "How to notify the changed event, channel: PUBLISH to redis-channel, stream: XADD to redis-stream",
+			Value: "channel",
+		},
 		cli.StringFlag{
 			Name:  "filename,f",
 			Usage: "JSON file for import to redis",
@@ -62,6 +82,11 @@ func main() {
 			Name:  "config-name",
 			Usage: "name of config struct, defualt will use josn filename(exclude file ext)",
 		},
+		cli.StringFlag{
+			Name:  "slice-encoding",
+			Usage: "How to encode the arrays, auto: JSON array while the elements contain comma or are not scalar, otherwise comma separated, csv: always comma separated, json: always JSON array",
+			Value: "auto",
+		},
 		cli.StringFlag{
 			Name:  "workdir,w",
 			Usage: "change work dir before sync",
@@ -97,14 +122,46 @@ func sync(ctx *cli.Context) (err error) {
 	}
 
 	channel := ctx.String("channel")
+	stream := ctx.String("stream")
 	notify := ctx.Bool("notify")
-	if notify && len(channel) == 0 {
-		err = fmt.Errorf("notify channel is empty")
+	batch := ctx.Bool("batch")
+	notifyMode := ctx.String("notify-mode")
+	streamMaxLen := ctx.Int("stream-maxlen")
+
+	if notify {
+		switch notifyMode {
+		case "channel":
+			if len(channel) == 0 {
+				err = fmt.Errorf("notify channel is empty")
+				return
+			}
+		case "stream":
+			if len(stream) == 0 {
+				err = fmt.Errorf("notify stream is empty")
+				return
+			}
+
+			if streamMaxLen < 0 {
+				err = fmt.Errorf("stream maxlen %d is negative", streamMaxLen)
+				return
+			}
+		default:
+			err = fmt.Errorf("unknown notify mode: %s", notifyMode)
+			return
+		}
+	}
+
+	sliceEncoding := ctx.String("slice-encoding")
+
+	switch sliceEncoding {
+	case "auto", "csv", "json":
+	default:
+		err = fmt.Errorf("unknown slice encoding: %s", sliceEncoding)
 		return
 	}
 
 	var kv map[string]string
-	if kv, err = loadData(filename, configName); err != nil {
+	if kv, err = loadData(filename, configName, sliceEncoding); err != nil {
 		return
 	}
 
@@ -114,11 +171,21 @@ func sync(ctx *cli.Context) (err error) {
 	pwd := ctx.String("redis-password")
 	namespace := ctx.String("namespace")
 
-	changed, errs := pushToRedis(host, port, pwd, db, namespace, kv, notify, channel)
+	notifier := &changeNotifier{
+		enabled: notify,
+		batch:   batch,
+		mode:    notifyMode,
+		channel: channel,
+		stream:  stream,
+		maxLen:  streamMaxLen,
+	}
+
+	changed, errs := pushToRedis(host, port, pwd, db, namespace, kv, notifier)
 
 	if errs != nil {
 		fmt.Println("ERRORS:\n-----------------------------------")
-		fmt.Println(errs.Error(), "\n")
+		fmt.Println(errs.Error())
+		fmt.Println("")
 	}
 
 	if len(changed) > 0 {
@@ -134,7 +201,48 @@ func sync(ctx *cli.Context) (err error) {
 	return
 }
 
-func pushToRedis(host string, port int, password string, db int, namespace string, data map[string]string, notify bool, channel string) (changed map[string]string, errs error) {
+type changeNotifier struct {
+	enabled bool
+	batch   bool
+	mode    string
+	channel string
+	stream  string
+	maxLen  int
+}
+
+// notify the keys by one message, the single key is sent as it is, and the keys
+// changed together are sent as a JSON array, and applied by RedConf as one change set
+func (p *changeNotifier) notify(conn redis.Conn, keys []string) (err error) {
+
+	if len(keys) == 0 {
+		return
+	}
+
+	field, msg := "key", keys[0]
+
+	if len(keys) > 1 {
+		var data []byte
+		if data, err = json.Marshal(keys); err != nil {
+			return
+		}
+		field, msg = "keys", string(data)
+	}
+
+	switch p.mode {
+	case "stream":
+		// trim the stream approximately, so the old entries are removed efficiently
+		if p.maxLen > 0 {
+			_, err = conn.Do("XADD", p.stream, "MAXLEN", "~", p.maxLen, "*", field, msg)
+		} else {
+			_, err = conn.Do("XADD", p.stream, "*", field, msg)
+		}
+	default:
+		_, err = conn.Do("PUBLISH", p.channel, msg)
+	}
+	return
+}
+
+func pushToRedis(host string, port int, password string, db int, namespace string, data map[string]string, notifier *changeNotifier) (changed map[string]string, errs error) {
 
 	if port == 0 {
 		port = 6379
@@ -167,6 +275,12 @@ func pushToRedis(host string, port int, password string, db int, namespace strin
 
 	changed = map[string]string{}
 
+	conn := pool.Get()
+	defer conn.Close()
+
+	var changedKeys []string
+	newValues := map[string]string{}
+
 	for k, v := range data {
 		if len(k) == 0 {
 			continue
@@ -177,27 +291,60 @@ func pushToRedis(host string, port int, password string, db int, namespace strin
 			key = namespace + ":" + k
 		}
 
-		conn := pool.Get()
 		if ret, e := conn.Do("GET", key); e != nil {
 			syncFailure[key] = e.Error()
 			continue
 		} else {
-			conn = pool.Get()
 			oldV, _ := redis.String(ret, e)
 
 			if oldV != v {
-				if _, e := conn.Do("SET", key, v); e != nil {
-					syncFailure[key] = e.Error()
-					continue
-				}
-
+				changedKeys = append(changedKeys, key)
+				newValues[key] = v
 				changed[key] = fmt.Sprintf("%s ==> %s", oldV, v)
+			}
+		}
+	}
+
+	sort.Strings(changedKeys)
 
-				if notify {
-					conn = pool.Get()
-					if _, e := conn.Do("PUBLISH", channel, key); e != nil {
-						notifyFailure[key] = e.Error()
-					}
+	// write the changed keys in one transaction, so they are changed together
+	if len(changedKeys) > 0 {
+		e := conn.Send("MULTI")
+		for _, key := range changedKeys {
+			if e == nil {
+				e = conn.Send("SET", key, newValues[key])
+			}
+		}
+
+		if e == nil {
+			_, e = conn.Do("EXEC")
+		}
+
+		if e != nil {
+			for _, key := range changedKeys {
+				syncFailure[key] = e.Error()
+				delete(changed, key)
+			}
+			changedKeys = nil
+		}
+	}
+
+	if notifier.enabled && len(changedKeys) > 0 {
+
+		// notify the keys one by one by default, the monitors of old version
+		// could not parse the batch message
+		batches := [][]string{changedKeys}
+		if !notifier.batch {
+			batches = nil
+			for _, key := range changedKeys {
+				batches = append(batches, []string{key})
+			}
+		}
+
+		for _, keys := range batches {
+			if e := notifier.notify(conn, keys); e != nil {
+				for _, key := range keys {
+					notifyFailure[key] = e.Error()
 				}
 			}
 		}
@@ -224,7 +371,7 @@ func pushToRedis(host string, port int, password string, db int, namespace strin
 	return
 }
 
-func loadData(filename, configName string) (kv map[string]string, err error) {
+func loadData(filename, configName, sliceEncoding string) (kv map[string]string, err error) {
 	var data []byte
 	if data, err = ioutil.ReadFile(filename); err != nil {
 		return
@@ -246,13 +393,15 @@ func loadData(filename, configName string) (kv map[string]string, err error) {
 
 	resultKV := map[string]string{}
 
-	deepInMap(&configName, tmpMap, resultKV)
+	if err = deepInMap(&configName, tmpMap, resultKV, sliceEncoding); err != nil {
+		return
+	}
 
 	kv = resultKV
 	return
 }
 
-func deepInMap(prefix *string, m interface{}, resultKV map[string]string) {
+func deepInMap(prefix *string, m interface{}, resultKV map[string]string, sliceEncoding string) (err error) {
 	switch typedM := m.(type) {
 	case map[string]interface{}:
 		{
@@ -263,7 +412,9 @@ func deepInMap(prefix *string, m interface{}, resultKV map[string]string) {
 				} else {
 					newPrefix = k
 				}
-				deepInMap(&newPrefix, v, resultKV)
+				if err = deepInMap(&newPrefix, v, resultKV, sliceEncoding); err != nil {
+					return
+				}
 			}
 		}
 	default:
@@ -271,11 +422,7 @@ func deepInMap(prefix *string, m interface{}, resultKV map[string]string) {
 			switch v := m.(type) {
 			case []interface{}:
 				{
-					var tmpStrV []string
-					for i := 0; i < len(v); i++ {
-						tmpStrV = append(tmpStrV, fmt.Sprintf("%v", v[i]))
-					}
-					resultKV[*prefix] = strings.Join(tmpStrV, ",")
+					resultKV[*prefix], err = encodeSlice(v, sliceEncoding)
 				}
 			default:
 				{
@@ -286,4 +433,43 @@ func deepInMap(prefix *string, m interface{}, resultKV map[string]string) {
 			resultKV[*prefix] = ""
 		}
 	}
+
+	return
+}
+
+// encodeSlice encode the array as the comma separated value, or the JSON array
+// which is decoded by RedConf while the elements contain comma
+func encodeSlice(v []interface{}, sliceEncoding string) (str string, err error) {
+
+	useJSON := sliceEncoding == "json"
+
+	var tmpStrV []string
+	for i := 0; i < len(v); i++ {
+		strV := fmt.Sprintf("%v", v[i])
+
+		if sliceEncoding == "auto" {
+			switch v[i].(type) {
+			case map[string]interface{}, []interface{}:
+				useJSON = true
+			default:
+				useJSON = useJSON || strings.Contains(strV, ",")
+			}
+		}
+
+		tmpStrV = append(tmpStrV, strV)
+	}
+
+	if !useJSON {
+		str = strings.Join(tmpStrV, ",")
+		return
+	}
+
+	var data []byte
+	if data, err = json.Marshal(v); err != nil {
+		return
+	}
+
+	str = string(data)
+
+	return
 }
@@ -2,19 +2,91 @@ package redconf
 
 import (
 	"bytes"
+	"encoding"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"reflect"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
-type convFunc func(typ reflect.Type, value interface{}, toPtr bool) (v interface{}, err error)
+type convFunc func(typ reflect.Type, value interface{}, opts convOptions) (v interface{}, err error)
+
+const (
+	EncodingRaw    = "raw"
+	EncodingBase64 = "base64"
+	EncodingJSON   = "json"
+)
+
+const (
+	MapModeKeys = "keys"
+	MapModeHash = "hash"
+)
+
+// convOptions is parsed from the tag of field, the encoding of []byte could be
+// raw (default), base64 or json, such as `redconf:"key,encoding=base64"`, the slice
+// is split by the sep (default is comma), or decoded as JSON array while the encoding
+// is json or the value looks like a JSON array, such as `redconf:"hosts,sep=;"`,
+// the map is stored as JSON by default, or one entry per sub key while the map is keys,
+// or as a hash while the map is hash, such as `redconf:"limits,map=keys"`
+type convOptions struct {
+	encoding string
+	sep      string
+	mapMode  string
+}
+
+func newConvOptions(tag fieldTag) (opts convOptions, err error) {
+
+	if encoding, exist := tag.option("encoding"); exist {
+		switch encoding {
+		case EncodingRaw, EncodingBase64, EncodingJSON:
+		default:
+			err = fmt.Errorf("redconf: unknown encoding of %s", encoding)
+			return
+		}
+		opts.encoding = encoding
+	}
+
+	if sep, exist := tag.option("sep"); exist {
+		if sep == "" {
+			err = errors.New("redconf: the sep could not be empty")
+			return
+		}
+		opts.sep = sep
+	}
+
+	if mapMode, exist := tag.option("map"); exist {
+		switch mapMode {
+		case MapModeKeys, MapModeHash:
+		default:
+			err = fmt.Errorf("redconf: unknown map mode of %s", mapMode)
+			return
+		}
+		opts.mapMode = mapMode
+	}
+
+	return
+}
+
+// Converter conv the raw value from storage to the registered type, the raw value
+// is string for the redis and file storages, it is not called for the missing keys
+type Converter func(raw interface{}) (v interface{}, err error)
 
 var (
 	convFuncs = make(map[reflect.Kind]convFunc)
 
 	defaultValue = make(map[reflect.Kind]interface{})
+
+	durationType        = reflect.TypeOf(time.Duration(0))
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+	converters       = make(map[reflect.Type]Converter)
+	convertersLocker sync.RWMutex
 )
 
 func init() {
@@ -33,6 +105,7 @@ func init() {
 	convFuncs[reflect.Float64] = convFloatValue
 	convFuncs[reflect.String] = convStringValue
 	convFuncs[reflect.Slice] = convSliceValue
+	convFuncs[reflect.Array] = convArrayValue
 	convFuncs[reflect.Struct] = convStructValue
 	convFuncs[reflect.Map] = convMapValue
 	convFuncs[reflect.Ptr] = convPtrValue
@@ -54,24 +127,208 @@ func init() {
 	defaultValue[reflect.Slice] = nil
 }
 
+// RegisterConverter register the converter of the type, such as net.IP, *url.URL,
+// *regexp.Regexp or the enum types, it takes precedence over the conv of kind
+func RegisterConverter(typ reflect.Type, converter Converter) (err error) {
+	if typ == nil {
+		err = errors.New("redconf: converter type could not be nil")
+		return
+	}
+
+	if converter == nil {
+		err = errors.New("redconf: converter could not be nil")
+		return
+	}
+
+	convertersLocker.Lock()
+	defer convertersLocker.Unlock()
+
+	if _, exist := converters[typ]; exist {
+		err = errors.New("redconf: converter of " + typ.String() + " already exist")
+		return
+	}
+
+	converters[typ] = converter
+
+	return
+}
+
+func getConverter(typ reflect.Type) (converter Converter, exist bool) {
+	convertersLocker.RLock()
+	defer convertersLocker.RUnlock()
+
+	converter, exist = converters[typ]
+	return
+}
+
+func convByConverter(converter Converter, typ reflect.Type, value interface{}) (v interface{}, err error) {
+	if value == nil {
+		return
+	}
+
+	if v, err = converter(value); err != nil || v == nil {
+		return
+	}
+
+	if vType := reflect.TypeOf(v); !vType.AssignableTo(typ) {
+		err = fmt.Errorf("redconf: the converter of %s returned the value of %s", typ, vType)
+		v = nil
+		return
+	}
+
+	return
+}
+
 func getZeroValue(typ reflect.Type) (v interface{}) {
 	v, _ = defaultValue[typ.Kind()]
 	return
 }
 
-func conv(typ reflect.Type, value interface{}) (v interface{}, err error) {
-	return convValue(typ, value, false)
+func conv(typ reflect.Type, value interface{}, opts convOptions) (v interface{}, err error) {
+	return convValue(typ, value, opts)
 }
 
-func convValue(typ reflect.Type, value interface{}, toPtr bool) (v interface{}, err error) {
+func convValue(typ reflect.Type, value interface{}, opts convOptions) (v interface{}, err error) {
+	if fn, exist := typedConvFunc(typ); exist {
+		return fn(typ, value, opts)
+	}
+
 	if fn, exist := convFuncs[typ.Kind()]; exist {
-		return fn(typ, value, toPtr)
+		if v, err = fn(typ, value, opts); err != nil || v == nil {
+			return
+		}
+
+		// the named types like `type Level string`
+		if val := reflect.ValueOf(v); val.Type() != typ && val.Type().ConvertibleTo(typ) {
+			v = val.Convert(typ).Interface()
+		}
+		return
 	}
 	err = fmt.Errorf("could not conv Kind of %#v", typ.Kind())
 	return
 }
 
-func convIntValue(typ reflect.Type, value interface{}, toPtr bool) (v interface{}, err error) {
+// typedConvFunc return the conv func by the type, it takes precedence over the kind,
+// the registered converters first, then time.Time and the other types implemented
+// encoding.TextUnmarshaler or json.Unmarshaler are conv by themselves
+func typedConvFunc(typ reflect.Type) (fn convFunc, exist bool) {
+	if converter, registered := getConverter(typ); registered {
+		return func(typ reflect.Type, value interface{}, opts convOptions) (interface{}, error) {
+			return convByConverter(converter, typ, value)
+		}, true
+	}
+
+	switch {
+	case typ == durationType:
+		return convDurationValue, true
+	case reflect.PtrTo(typ).Implements(textUnmarshalerType):
+		return convTextValue, true
+	case reflect.PtrTo(typ).Implements(jsonUnmarshalerType):
+		return convJSONValue, true
+	}
+
+	return
+}
+
+// isTypedValue return true while the type or the type pointed to is conv by the type,
+// the struct of it is a value but not the nested config
+func isTypedValue(typ reflect.Type) bool {
+	if _, exist := typedConvFunc(typ); exist {
+		return true
+	}
+
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+
+	_, exist := typedConvFunc(typ)
+	return exist
+}
+
+// convDurationValue parse the duration like 30s or 1h30m,
+// and the number is nanoseconds as before
+func convDurationValue(typ reflect.Type, value interface{}, opts convOptions) (v interface{}, err error) {
+	if value == nil {
+		v = time.Duration(0)
+		return
+	}
+
+	strV := strings.TrimSpace(fmt.Sprintf("%s", value))
+
+	if strV == "" {
+		v = time.Duration(0)
+		return
+	}
+
+	if intV, e := strconv.ParseInt(strV, 10, 64); e == nil {
+		v = time.Duration(intV)
+		return
+	}
+
+	var d time.Duration
+	if d, err = time.ParseDuration(strV); err != nil {
+		return
+	}
+
+	v = d
+
+	return
+}
+
+// convTextValue conv by encoding.TextUnmarshaler, such as time.Time of RFC3339
+func convTextValue(typ reflect.Type, value interface{}, opts convOptions) (v interface{}, err error) {
+
+	newV := reflect.New(typ)
+
+	strV := ""
+	if value != nil {
+		strV = strings.TrimSpace(fmt.Sprintf("%s", value))
+	}
+
+	if strV != "" {
+		if err = newV.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(strV)); err != nil {
+			return
+		}
+	}
+
+	v = newV.Elem().Interface()
+
+	return
+}
+
+// convJSONValue conv by json.Unmarshaler, the value which is not JSON will be
+// quoted as JSON string, so the string value is not need to be quoted in storage
+func convJSONValue(typ reflect.Type, value interface{}, opts convOptions) (v interface{}, err error) {
+
+	newV := reflect.New(typ)
+
+	strV := ""
+	if value != nil {
+		strV = strings.TrimSpace(fmt.Sprintf("%s", value))
+	}
+
+	if strV != "" {
+		unmarshaler := newV.Interface().(json.Unmarshaler)
+
+		if !json.Valid([]byte(strV)) {
+			var quoted []byte
+			if quoted, err = json.Marshal(strV); err != nil {
+				return
+			}
+			strV = string(quoted)
+		}
+
+		if err = unmarshaler.UnmarshalJSON([]byte(strV)); err != nil {
+			return
+		}
+	}
+
+	v = newV.Elem().Interface()
+
+	return
+}
+
+func convIntValue(typ reflect.Type, value interface{}, opts convOptions) (v interface{}, err error) {
 	if value == nil {
 		v = getZeroValue(typ)
 		return
@@ -127,7 +384,7 @@ func convIntValue(typ reflect.Type, value interface{}, toPtr bool) (v interface{
 	return
 }
 
-func convUintValue(typ reflect.Type, value interface{}, toPtr bool) (v interface{}, err error) {
+func convUintValue(typ reflect.Type, value interface{}, opts convOptions) (v interface{}, err error) {
 	if value == nil {
 		v = getZeroValue(typ)
 		return
@@ -148,23 +405,23 @@ func convUintValue(typ reflect.Type, value interface{}, toPtr bool) (v interface
 			}
 
 			switch typ.Kind() {
-			case reflect.Int:
+			case reflect.Uint:
 				{
 					v = uint(intV)
 				}
-			case reflect.Int8:
+			case reflect.Uint8:
 				{
 					v = uint8(intV)
 				}
-			case reflect.Int16:
+			case reflect.Uint16:
 				{
 					v = uint16(intV)
 				}
-			case reflect.Int32:
+			case reflect.Uint32:
 				{
 					v = uint32(intV)
 				}
-			case reflect.Int64:
+			case reflect.Uint64:
 				{
 					v = intV
 				}
@@ -177,7 +434,7 @@ func convUintValue(typ reflect.Type, value interface{}, toPtr bool) (v interface
 	return
 }
 
-func convFloatValue(typ reflect.Type, value interface{}, toPtr bool) (v interface{}, err error) {
+func convFloatValue(typ reflect.Type, value interface{}, opts convOptions) (v interface{}, err error) {
 	if value == nil {
 		v = getZeroValue(typ)
 		return
@@ -210,7 +467,7 @@ func convFloatValue(typ reflect.Type, value interface{}, toPtr bool) (v interfac
 	return
 }
 
-func convBoolValue(typ reflect.Type, value interface{}, toPtr bool) (v interface{}, err error) {
+func convBoolValue(typ reflect.Type, value interface{}, opts convOptions) (v interface{}, err error) {
 	if value == nil {
 		v = getZeroValue(typ)
 		return
@@ -239,7 +496,7 @@ func convBoolValue(typ reflect.Type, value interface{}, toPtr bool) (v interface
 	return
 }
 
-func convStringValue(typ reflect.Type, value interface{}, toPtr bool) (v interface{}, err error) {
+func convStringValue(typ reflect.Type, value interface{}, opts convOptions) (v interface{}, err error) {
 	if value == nil {
 		v = getZeroValue(typ)
 		return
@@ -248,21 +505,21 @@ func convStringValue(typ reflect.Type, value interface{}, toPtr bool) (v interfa
 	strV := fmt.Sprintf("%s", value)
 	strV = strings.TrimSpace(strV)
 
-	if toPtr {
-		v = &strV
-	} else {
-		v = strV
-	}
+	v = strV
 
 	return
 }
 
-func convSliceValue(typ reflect.Type, value interface{}, toPtr bool) (v interface{}, err error) {
+func convSliceValue(typ reflect.Type, value interface{}, opts convOptions) (v interface{}, err error) {
 	if value == nil {
 		v = getZeroValue(typ)
 		return
 	}
 
+	if typ.Elem().Kind() == reflect.Uint8 {
+		return convBytesValue(typ, value, opts)
+	}
+
 	tmpIvs := reflect.MakeSlice(typ, 1, 1)
 	oneVType := tmpIvs.Index(0).Type()
 
@@ -285,10 +542,12 @@ func convSliceValue(typ reflect.Type, value interface{}, toPtr bool) (v interfac
 		reflect.Float64,
 		reflect.String:
 		{
+			var strVs []string
+			if strVs, err = splitSliceValue(strV, opts); err != nil {
+				return
+			}
 
-			strVs := strings.Split(strV, ",")
-
-			if strV == "" || strVs == nil || len(strVs) == 0 {
+			if len(strVs) == 0 {
 				v = reflect.MakeSlice(typ, 0, 0).Interface()
 				return
 			}
@@ -297,7 +556,7 @@ func convSliceValue(typ reflect.Type, value interface{}, toPtr bool) (v interfac
 
 			for i := 0; i < len(strVs); i++ {
 				var oneV interface{}
-				if oneV, err = convValue(oneVType, strVs[i], false); err != nil {
+				if oneV, err = convValue(oneVType, strVs[i], opts); err != nil {
 					return
 				}
 
@@ -308,7 +567,7 @@ func convSliceValue(typ reflect.Type, value interface{}, toPtr bool) (v interfac
 		}
 	case reflect.Map:
 		{
-			v, err = convMapValue(typ, value, false)
+			v, err = convMapValue(typ, value, opts)
 		}
 	case reflect.Struct:
 		{
@@ -325,6 +584,110 @@ func convSliceValue(typ reflect.Type, value interface{}, toPtr bool) (v interfac
 	return
 }
 
+// splitSliceValue decode the value as JSON array while the encoding is json or the value
+// looks like a JSON array, otherwise split it by the sep, and the elements are trimmed
+func splitSliceValue(strV string, opts convOptions) (elems []string, err error) {
+
+	if strV == "" {
+		return
+	}
+
+	looksLikeJSON := strings.HasPrefix(strV, "[") && strings.HasSuffix(strV, "]")
+
+	if opts.encoding == EncodingJSON || looksLikeJSON {
+		var rawElems []json.RawMessage
+
+		if e := json.Unmarshal([]byte(strV), &rawElems); e == nil {
+			for _, raw := range rawElems {
+				var elem string
+				if json.Unmarshal(raw, &elem) != nil {
+					elem = string(raw)
+				}
+				elems = append(elems, elem)
+			}
+			return
+		} else if opts.encoding == EncodingJSON {
+			err = fmt.Errorf("redconf: the value is not a json array, %s", e)
+			return
+		}
+	}
+
+	sep := opts.sep
+	if sep == "" {
+		sep = ","
+	}
+
+	for _, elem := range strings.Split(strV, sep) {
+		elems = append(elems, strings.TrimSpace(elem))
+	}
+
+	return
+}
+
+// convBytesValue conv the value to []byte as raw string, or decode it by the encoding
+func convBytesValue(typ reflect.Type, value interface{}, opts convOptions) (v interface{}, err error) {
+
+	var data []byte
+
+	switch rawV := value.(type) {
+	case []byte:
+		data = append([]byte(nil), rawV...)
+	default:
+		data = []byte(fmt.Sprintf("%s", value))
+	}
+
+	switch opts.encoding {
+	case EncodingBase64:
+		if data, err = base64.StdEncoding.DecodeString(strings.TrimSpace(string(data))); err != nil {
+			return
+		}
+	case EncodingJSON:
+		var decoded []byte
+		if err = json.Unmarshal(data, &decoded); err != nil {
+			return
+		}
+		data = decoded
+	}
+
+	v = data
+
+	return
+}
+
+// convArrayValue conv the value as slice, the length of it should be the same as the array
+func convArrayValue(typ reflect.Type, value interface{}, opts convOptions) (v interface{}, err error) {
+
+	arrV := reflect.New(typ).Elem()
+
+	if value == nil {
+		v = arrV.Interface()
+		return
+	}
+
+	var sliceV interface{}
+	if sliceV, err = convValue(reflect.SliceOf(typ.Elem()), value, opts); err != nil {
+		return
+	}
+
+	if sliceV == nil || reflect.ValueOf(sliceV).Len() == 0 {
+		v = arrV.Interface()
+		return
+	}
+
+	slice := reflect.ValueOf(sliceV)
+
+	if slice.Len() != typ.Len() {
+		err = fmt.Errorf("redconf: the length %d of value is not equal to the length %d of array", slice.Len(), typ.Len())
+		return
+	}
+
+	reflect.Copy(arrV, slice)
+
+	v = arrV.Interface()
+
+	return
+}
+
 func convStructSliceValue(typ reflect.Type, str string) (v interface{}, err error) {
 	if str == "" {
 		return
@@ -346,7 +709,7 @@ func convStructSliceValue(typ reflect.Type, str string) (v interface{}, err erro
 	return
 }
 
-func convStructValue(typ reflect.Type, value interface{}, toPtr bool) (v interface{}, err error) {
+func convStructValue(typ reflect.Type, value interface{}, opts convOptions) (v interface{}, err error) {
 	if value == nil {
 		v = reflect.New(typ).Elem().Interface()
 		return
@@ -375,27 +738,38 @@ func convStructValue(typ reflect.Type, value interface{}, toPtr bool) (v interfa
 	return
 }
 
-func convPtrValue(typ reflect.Type, value interface{}, toPtr bool) (v interface{}, err error) {
+func convPtrValue(typ reflect.Type, value interface{}, opts convOptions) (v interface{}, err error) {
 
 	if value == nil {
 		return
 	}
 
 	var newV interface{}
-	if newV, err = convValue(typ.Elem(), value, true); err != nil {
+	if newV, err = convValue(typ.Elem(), value, opts); err != nil {
 		return
 	}
 
-	v = newV
+	ptr := reflect.New(typ.Elem())
+
+	if newV != nil {
+		ptr.Elem().Set(reflect.ValueOf(newV))
+	}
+
+	v = ptr.Interface()
 
 	return
 }
 
-func convMapValue(typ reflect.Type, value interface{}, toPtr bool) (v interface{}, err error) {
+func convMapValue(typ reflect.Type, value interface{}, opts convOptions) (v interface{}, err error) {
 	if value == nil {
 		return
 	}
 
+	// the entries got from the sub keys or hash
+	if entries, ok := value.(map[string]interface{}); ok {
+		return convMapEntries(typ, entries, opts)
+	}
+
 	strV := fmt.Sprintf("%s", value)
 	strV = strings.TrimSpace(strV)
 
@@ -419,3 +793,32 @@ func convMapValue(typ reflect.Type, value interface{}, toPtr bool) (v interface{
 
 	return
 }
+
+// convMapEntries conv the raw values of entries to the elem type of map
+func convMapEntries(typ reflect.Type, entries map[string]interface{}, opts convOptions) (v interface{}, err error) {
+
+	mapV := reflect.MakeMapWithSize(typ, len(entries))
+
+	for name, raw := range entries {
+		if raw == nil {
+			continue
+		}
+
+		var elem interface{}
+		if elem, err = convValue(typ.Elem(), raw, opts); err != nil {
+			err = fmt.Errorf("redconf: conv the entry of %s failure, %s", name, err)
+			return
+		}
+
+		elemV := reflect.Zero(typ.Elem())
+		if elem != nil {
+			elemV = reflect.ValueOf(elem)
+		}
+
+		mapV.SetMapIndex(reflect.ValueOf(name).Convert(typ.Key()), elemV)
+	}
+
+	v = mapV.Interface()
+
+	return
+}
@@ -0,0 +1,311 @@
+package redconf
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testLevel string
+
+type testJSONLevel int
+
+func (p *testJSONLevel) UnmarshalJSON(data []byte) (err error) {
+	var str string
+	if err = json.Unmarshal(data, &str); err != nil {
+		return
+	}
+	*p = testJSONLevel(len(str))
+	return
+}
+
+func TestConvTypedValue(t *testing.T) {
+
+	createdAt := time.Date(2017, 6, 1, 8, 30, 0, 0, time.UTC)
+	port := 8080
+
+	cases := []struct {
+		typ      reflect.Type
+		value    interface{}
+		excepted interface{}
+	}{
+		{reflect.TypeOf(time.Duration(0)), "30s", time.Second * 30},
+		{reflect.TypeOf(time.Duration(0)), "1000", time.Duration(1000)},
+		{reflect.TypeOf(time.Duration(0)), "", time.Duration(0)},
+		{reflect.TypeOf(time.Time{}), "2017-06-01T08:30:00Z", createdAt},
+		{reflect.TypeOf(&createdAt), "2017-06-01T08:30:00Z", &createdAt},
+		{reflect.TypeOf(&port), "8080", &port},
+		{reflect.TypeOf(net.IP{}), "10.0.0.1", net.ParseIP("10.0.0.1")},
+		{reflect.TypeOf(testJSONLevel(0)), "info", testJSONLevel(4)},
+		{reflect.TypeOf(testJSONLevel(0)), `"debug"`, testJSONLevel(5)},
+		{reflect.TypeOf(testLevel("")), "warn", testLevel("warn")},
+	}
+
+	for _, c := range cases {
+		v, err := conv(c.typ, c.value, convOptions{})
+		if err != nil {
+			t.Errorf("conv %v to %s failed: %s", c.value, c.typ, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(v, c.excepted) {
+			t.Errorf("conv %v to %s excepted: %#v, got: %#v", c.value, c.typ, c.excepted, v)
+		}
+	}
+
+	if _, err := conv(reflect.TypeOf(time.Duration(0)), "30 seconds", convOptions{}); err == nil || !strings.Contains(err.Error(), "duration") {
+		t.Errorf("conv the invalid duration should be failed, got: %v", err)
+	}
+
+	if _, err := conv(reflect.TypeOf(time.Time{}), "2017-06-01", convOptions{}); err == nil {
+		t.Error("conv the time which is not RFC3339 should be failed")
+	}
+}
+
+type TestTypedConfig struct {
+	Timeout   time.Duration
+	CreatedAt time.Time
+	UpdatedAt *time.Time
+}
+
+func TestRedConfTypedField(t *testing.T) {
+
+	redConf, storage := newMemoryRedConf(t, "NS")
+	defer redConf.Close()
+
+	storage.Set("NS", "TestTypedConfig:Timeout", "1m30s")
+	storage.Set("NS", "TestTypedConfig:CreatedAt", "2017-06-01T08:30:00+08:00")
+
+	conf := TestTypedConfig{}
+
+	if err := redConf.Watch(&conf); err != nil {
+		t.Error(err)
+		return
+	}
+
+	exceptedKeys := []string{"TestTypedConfig:CreatedAt", "TestTypedConfig:Timeout", "TestTypedConfig:UpdatedAt"}
+	keys := redConf.Keys()
+	sort.Strings(keys)
+
+	if !reflect.DeepEqual(keys, exceptedKeys) {
+		t.Errorf("the time should be the value of field, got keys: %v", keys)
+		return
+	}
+
+	if conf.Timeout != time.Second*90 || conf.CreatedAt.Unix() != 1496277000 || conf.UpdatedAt != nil {
+		t.Errorf("sync typed config failed: %#v", conf)
+		return
+	}
+
+	storage.Set("NS", "TestTypedConfig:UpdatedAt", "2017-06-02T08:30:00Z")
+
+	if conf.UpdatedAt == nil || conf.UpdatedAt.Day() != 2 {
+		t.Errorf("update the pointer of time failed: %#v", conf)
+		return
+	}
+}
+
+func TestConvUintValue(t *testing.T) {
+
+	cases := []struct {
+		typ      reflect.Type
+		value    interface{}
+		excepted interface{}
+	}{
+		{reflect.TypeOf(uint(0)), "8080", uint(8080)},
+		{reflect.TypeOf(uint8(0)), "255", uint8(255)},
+		{reflect.TypeOf(uint16(0)), "65535", uint16(65535)},
+		{reflect.TypeOf(uint32(0)), "4294967295", uint32(4294967295)},
+		{reflect.TypeOf(uint64(0)), "18446744073709551615", uint64(18446744073709551615)},
+	}
+
+	for _, c := range cases {
+		v, err := conv(c.typ, c.value, convOptions{})
+		if err != nil {
+			t.Errorf("conv %v to %s failed: %s", c.value, c.typ, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(v, c.excepted) {
+			t.Errorf("conv %v to %s excepted: %#v, got: %#v", c.value, c.typ, c.excepted, v)
+		}
+	}
+}
+
+type testByteSize int64
+
+type testBadConverterType string
+
+func parseTestByteSize(raw interface{}) (v interface{}, err error) {
+	str := strings.ToUpper(strings.TrimSpace(fmt.Sprintf("%s", raw)))
+
+	unit := int64(1)
+	switch {
+	case strings.HasSuffix(str, "MB"):
+		unit, str = 1<<20, strings.TrimSuffix(str, "MB")
+	case strings.HasSuffix(str, "KB"):
+		unit, str = 1<<10, strings.TrimSuffix(str, "KB")
+	}
+
+	var size int64
+	if size, err = strconv.ParseInt(str, 10, 64); err != nil {
+		return
+	}
+
+	return testByteSize(size * unit), nil
+}
+
+var (
+	_ = RegisterConverter(reflect.TypeOf(testByteSize(0)), parseTestByteSize)
+	_ = RegisterConverter(reflect.TypeOf(&url.URL{}), func(raw interface{}) (interface{}, error) {
+		return url.Parse(fmt.Sprintf("%s", raw))
+	})
+	_ = RegisterConverter(reflect.TypeOf(testBadConverterType("")), func(raw interface{}) (interface{}, error) {
+		return "not a testBadConverterType", nil
+	})
+)
+
+type TestConverterConfig struct {
+	MaxBodySize testByteSize
+	Limits      []testByteSize
+	Endpoint    *url.URL
+}
+
+func TestRegisterConverter(t *testing.T) {
+
+	if err := RegisterConverter(reflect.TypeOf(testByteSize(0)), parseTestByteSize); err == nil {
+		t.Error("register the converter twice should be failed")
+		return
+	}
+
+	if _, err := conv(reflect.TypeOf(testBadConverterType("")), "info", convOptions{}); err == nil {
+		t.Error("the value returned by converter should be the registered type")
+		return
+	}
+
+	redConf, storage := newMemoryRedConf(t, "NS")
+	defer redConf.Close()
+
+	storage.Set("NS", "TestConverterConfig:MaxBodySize", "10MB")
+	storage.Set("NS", "TestConverterConfig:Limits", "1KB,2KB")
+	storage.Set("NS", "TestConverterConfig:Endpoint", "https://example.com/api")
+
+	conf := TestConverterConfig{}
+
+	if err := redConf.Watch(&conf); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if conf.MaxBodySize != 10<<20 || !reflect.DeepEqual(conf.Limits, []testByteSize{1 << 10, 2 << 10}) {
+		t.Errorf("conv by the registered converter failed: %#v", conf)
+		return
+	}
+
+	if conf.Endpoint == nil || conf.Endpoint.Host != "example.com" {
+		t.Errorf("conv the pointer type by the registered converter failed: %#v", conf.Endpoint)
+		return
+	}
+}
+
+func TestConvArrayAndBytes(t *testing.T) {
+
+	key := [4]byte{1, 2, 3, 4}
+
+	cases := []struct {
+		typ      reflect.Type
+		value    interface{}
+		opts     convOptions
+		excepted interface{}
+	}{
+		{reflect.TypeOf([3]int{}), "1,2,3", convOptions{}, [3]int{1, 2, 3}},
+		{reflect.TypeOf([3]int{}), "", convOptions{}, [3]int{}},
+		{reflect.TypeOf([]byte{}), "a,b", convOptions{}, []byte("a,b")},
+		{reflect.TypeOf([]byte{}), []byte("raw"), convOptions{}, []byte("raw")},
+		{reflect.TypeOf([]byte{}), "aGVsbG8=", convOptions{encoding: EncodingBase64}, []byte("hello")},
+		{reflect.TypeOf(key), "AQIDBA==", convOptions{encoding: EncodingBase64}, key},
+	}
+
+	for _, c := range cases {
+		v, err := conv(c.typ, c.value, c.opts)
+		if err != nil {
+			t.Errorf("conv %v to %s failed: %s", c.value, c.typ, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(v, c.excepted) {
+			t.Errorf("conv %v to %s excepted: %#v, got: %#v", c.value, c.typ, c.excepted, v)
+		}
+	}
+
+	if _, err := conv(reflect.TypeOf([3]int{}), "1,2", convOptions{}); err == nil {
+		t.Error("conv the value which length is not equal to the array should be failed")
+	}
+
+	if _, err := conv(reflect.TypeOf([]byte{}), "not base64", convOptions{encoding: EncodingBase64}); err == nil {
+		t.Error("conv the invalid base64 value should be failed")
+	}
+
+	if _, err := newConvOptions(fieldTag{options: map[string]string{"encoding": "hex"}}); err == nil {
+		t.Error("the unknown encoding should be failed")
+	}
+}
+
+func TestConvSliceEncoding(t *testing.T) {
+
+	cases := []struct {
+		typ      reflect.Type
+		value    interface{}
+		opts     convOptions
+		excepted interface{}
+	}{
+		{reflect.TypeOf([]string{}), "a, b ,c", convOptions{}, []string{"a", "b", "c"}},
+		{reflect.TypeOf([]string{}), "a,b;c", convOptions{sep: ";"}, []string{"a,b", "c"}},
+		{reflect.TypeOf([]string{}), `["^a,b$", "c d"]`, convOptions{}, []string{"^a,b$", "c d"}},
+		{reflect.TypeOf([]string{}), `[a]`, convOptions{}, []string{"[a]"}},
+		{reflect.TypeOf([]int{}), "[1, 2]", convOptions{encoding: EncodingJSON}, []int{1, 2}},
+		{reflect.TypeOf([]int{}), "", convOptions{encoding: EncodingJSON}, []int{}},
+		{reflect.TypeOf([2]float64{}), "1.5 | 2", convOptions{sep: "|"}, [2]float64{1.5, 2}},
+		{reflect.TypeOf([]byte{}), `"aGVsbG8="`, convOptions{encoding: EncodingJSON}, []byte("hello")},
+	}
+
+	for _, c := range cases {
+		v, err := conv(c.typ, c.value, c.opts)
+		if err != nil {
+			t.Errorf("conv %v to %s failed: %s", c.value, c.typ, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(v, c.excepted) {
+			t.Errorf("conv %v to %s excepted: %#v, got: %#v", c.value, c.typ, c.excepted, v)
+		}
+	}
+
+	if _, err := conv(reflect.TypeOf([]string{}), "a,b", convOptions{encoding: EncodingJSON}); err == nil {
+		t.Error("conv the value which is not JSON array with encoding json should be failed")
+	}
+
+	tag, err := parseFieldTag("dsns,sep=;,default=a=1,b=2;c=3")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	opts, err := newConvOptions(tag)
+	if err != nil || opts.sep != ";" {
+		t.Errorf("parse the sep of tag failed: %#v, %v", opts, err)
+		return
+	}
+
+	v, err := conv(reflect.TypeOf([]string{}), tag.options["default"], opts)
+	if err != nil || !reflect.DeepEqual(v, []string{"a=1,b=2", "c=3"}) {
+		t.Errorf("conv the default value by the sep failed: %#v, %v", v, err)
+	}
+}
@@ -0,0 +1,253 @@
+package redconf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	DispatchOverflowBlock      = "block"
+	DispatchOverflowDropOldest = "drop_oldest"
+	DispatchOverflowDropNewest = "drop_newest"
+)
+
+const (
+	DefaultDispatchQueueSize = 1024
+)
+
+var (
+	ErrDispatchQueueFull = errors.New("redconf: the dispatch queue is full, the change is dropped")
+	ErrEventsBufferFull  = errors.New("redconf: the buffer of events channel is full, the event is dropped")
+)
+
+// dispatcher apply the changed keys from monitor one by one in the arrival order,
+// the values are got from storage while applying, so the later change always wins.
+// while the queue is full, the overflow policy decide to block the monitor or drop
+// the change, the dropped changes will be recovered by a resync.
+// with the queue size of 0, the changes are applied in the goroutine of monitor.
+// with the coalesce window, the changes within the window after the first change
+// are merged and delivered as one change set, but every change is still validated
+// and rejected on its own.
+// the events are published without the applying blocked, so the subscribers could
+// write the storage, with the queue the events are published by another goroutine
+// in the order of applying
+type dispatcher struct {
+	queue    chan []string
+	overflow string
+	window   time.Duration
+
+	resyncC chan struct{}
+
+	// serialize the applying while the changes are not queued
+	syncLock sync.Mutex
+
+	publishes   []func()
+	publishC    chan struct{}
+	publishLock sync.Mutex
+
+	process   func(changeSets [][]string) (publish func())
+	resync    func() (publish func())
+	onDropped func(keys []string)
+}
+
+func newDispatcher(queueSize int, overflow string, window time.Duration) (d *dispatcher, err error) {
+
+	if queueSize < 0 {
+		err = fmt.Errorf("redconf: the dispatch queue size %d is negative", queueSize)
+		return
+	}
+
+	if window < 0 {
+		err = fmt.Errorf("redconf: the coalesce window %s is negative", window)
+		return
+	}
+
+	if window > 0 && queueSize == 0 {
+		err = errors.New("redconf: the coalesce window could not work without the dispatch queue")
+		return
+	}
+
+	switch overflow {
+	case "":
+		overflow = DispatchOverflowBlock
+	case DispatchOverflowBlock, DispatchOverflowDropOldest, DispatchOverflowDropNewest:
+	default:
+		err = fmt.Errorf("redconf: unknown dispatch overflow policy of %s", overflow)
+		return
+	}
+
+	d = &dispatcher{
+		overflow: overflow,
+		window:   window,
+		resyncC:  make(chan struct{}, 1),
+		publishC: make(chan struct{}, 1),
+	}
+
+	if queueSize > 0 {
+		d.queue = make(chan []string, queueSize)
+	}
+
+	return
+}
+
+func (p *dispatcher) run(ctx context.Context) {
+
+	if p.queue == nil {
+		return
+	}
+
+	go p.runPublish(ctx)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case keys := <-p.queue:
+			p.enqueuePublish(p.process(p.coalesce(ctx, keys)))
+		case <-p.resyncC:
+			p.enqueuePublish(p.resync())
+		}
+	}
+}
+
+// enqueuePublish never blocks, so the applying goes on while a subscriber
+// is waiting for the queue which is full
+func (p *dispatcher) enqueuePublish(publish func()) {
+
+	if publish == nil {
+		return
+	}
+
+	p.publishLock.Lock()
+	p.publishes = append(p.publishes, publish)
+	p.publishLock.Unlock()
+
+	select {
+	case p.publishC <- struct{}{}:
+	default:
+	}
+}
+
+func (p *dispatcher) runPublish(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.publishC:
+		}
+
+		p.publishLock.Lock()
+		publishes := p.publishes
+		p.publishes = nil
+		p.publishLock.Unlock()
+
+		for _, publish := range publishes {
+			publish()
+		}
+	}
+}
+
+// syncCall apply in the goroutine of caller, and publish after the syncLock unlocked,
+// so the subscriber which writes the storage will not be blocked by itself
+func (p *dispatcher) syncCall(apply func() (publish func())) {
+
+	p.syncLock.Lock()
+	publish := apply()
+	p.syncLock.Unlock()
+
+	if publish != nil {
+		publish()
+	}
+}
+
+// coalesce collect the changes queued within the window after the first change,
+// the window is not extended by the later changes, so the keys changed
+// continuously will still be applied in time
+func (p *dispatcher) coalesce(ctx context.Context, keys []string) (changeSets [][]string) {
+
+	changeSets = append(changeSets, keys)
+
+	if p.window <= 0 {
+		return
+	}
+
+	timer := time.NewTimer(p.window)
+	defer timer.Stop()
+
+	for waiting := true; waiting; {
+		select {
+		case <-ctx.Done():
+			waiting = false
+		case <-timer.C:
+			waiting = false
+		case moreKeys := <-p.queue:
+			changeSets = append(changeSets, moreKeys)
+		}
+	}
+
+	return
+}
+
+func (p *dispatcher) dispatch(ctx context.Context, keys []string) {
+
+	if p.queue == nil {
+		p.syncCall(func() func() {
+			return p.process([][]string{keys})
+		})
+		return
+	}
+
+	switch p.overflow {
+	case DispatchOverflowDropNewest:
+		select {
+		case p.queue <- keys:
+		default:
+			p.drop(keys)
+		}
+	case DispatchOverflowDropOldest:
+		for {
+			select {
+			case p.queue <- keys:
+				return
+			default:
+			}
+
+			select {
+			case oldest := <-p.queue:
+				p.drop(oldest)
+			default:
+			}
+		}
+	default:
+		select {
+		case p.queue <- keys:
+		case <-ctx.Done():
+		}
+	}
+}
+
+// requestResync resync the keys after the queued changes, the requests
+// are merged while there is one waiting
+func (p *dispatcher) requestResync() {
+
+	if p.queue == nil {
+		p.syncCall(p.resync)
+		return
+	}
+
+	select {
+	case p.resyncC <- struct{}{}:
+	default:
+	}
+}
+
+func (p *dispatcher) drop(keys []string) {
+	if p.onDropped != nil {
+		p.onDropped(keys)
+	}
+
+	p.requestResync()
+}
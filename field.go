@@ -2,17 +2,18 @@ package redconf
 
 import (
 	"reflect"
-	"sync"
 )
 
 type Field struct {
 	name        string
 	parents     []string
 	structField reflect.StructField
-	topVal      interface{}
+	tag         fieldTag
+	validator   *fieldValidator
+	convOptions convOptions
+	conf        *WatchingConfig
 	level       int
 	str         string
-	valLock     sync.Mutex
 }
 
 func (p *Field) Name() string {
@@ -39,32 +40,52 @@ func (p *Field) Parents() []string {
 	return p.parents
 }
 
-func (p *Field) set(v interface{}) {
-	p.valLock.Lock()
-	defer p.valLock.Unlock()
+func (p *Field) Required() bool {
+	return p.tag.hasOption("required")
+}
+
+func (p *Field) Default() (value string, exist bool) {
+	return p.tag.option("default")
+}
 
-	val := reflect.ValueOf(p.topVal).Elem()
+// setOn set the value into the struct pointed by root, if copyParents is true,
+// the parent structs pointed by pointer will be copied before set, so the shared
+// structs with others will not be changed
+func (p *Field) setOn(root reflect.Value, v interface{}, copyParents bool) {
+
+	val := root.Elem()
 
 	for _, parent := range p.parents {
 		val = val.FieldByName(parent)
 		if val.Kind() == reflect.Ptr {
+			if copyParents && !val.IsNil() {
+				cp := reflect.New(val.Type().Elem())
+				cp.Elem().Set(val.Elem())
+				val.Set(cp)
+			}
 			val = val.Elem()
 		}
 	}
 
 	val = val.FieldByName(p.name)
 
-	if val.Kind() == reflect.Ptr && reflect.Zero(val.Type()) != v {
-		val.Set(reflect.ValueOf(v))
+	if v == nil {
+		val.Set(reflect.Zero(val.Type()))
 	} else {
 		val.Set(reflect.ValueOf(v))
 	}
+}
 
-	return
+func (p *Field) validate(v interface{}) (err error) {
+	if p.validator == nil {
+		return
+	}
+
+	return p.validator.validate(v)
 }
 
 func (p *Field) Value() (currentVal interface{}) {
-	val := reflect.ValueOf(p.topVal).Elem()
+	val := p.conf.root().Elem()
 
 	for _, parent := range p.parents {
 		val = val.FieldByName(parent)
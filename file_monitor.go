@@ -0,0 +1,235 @@
+package redconf
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	_ Monitor = (*FileMonitor)(nil)
+)
+
+const (
+	DefaultFileMonitorInterval = time.Second
+)
+
+// FileMonitor polling the modify time and size of the document file, while the file changed,
+// it re-parse the document and only callback the keys which the value changed,
+// the namespace is ignored as FileStorage
+type FileMonitor struct {
+	filename   string
+	format     string
+	configName string
+	interval   time.Duration
+
+	watchingNamespace map[string]chan struct{}
+	watchLocker       sync.Mutex
+	closed            bool
+}
+
+type fileSnapshot struct {
+	kv      map[string]string
+	modTime time.Time
+	size    int64
+}
+
+func init() {
+	RegisterMonitor("file", NewFileMonitor)
+}
+
+func NewFileMonitor(opts Options) (monitor Monitor, err error) {
+
+	filename := ""
+	format := ""
+	configName := ""
+	interval := DefaultFileMonitorInterval
+
+	opts.Get("filename", &filename)
+	opts.Get("format", &format)
+
+	if filename == "" {
+		err = fmt.Errorf("redconf: the option of filename is empty")
+		return
+	}
+
+	if format, err = fileFormat(filename, format); err != nil {
+		return
+	}
+
+	if exist := opts.Get("config_name", &configName); !exist {
+		configName = fileConfigName(filename)
+	}
+
+	if _, err = opts.GetDuration("interval", &interval); err != nil {
+		return
+	}
+
+	if interval <= 0 {
+		interval = DefaultFileMonitorInterval
+	}
+
+	monitor = &FileMonitor{
+		filename:          filename,
+		format:            format,
+		configName:        configName,
+		interval:          interval,
+		watchingNamespace: make(map[string]chan struct{}),
+	}
+
+	return
+}
+
+func (p *FileMonitor) Watch(namespace string, callback KeyContentChangedCallback, onError OnWatchingError) (err error) {
+
+	p.watchLocker.Lock()
+	defer p.watchLocker.Unlock()
+
+	if p.closed {
+		err = fmt.Errorf("redconf: file monitor already closed")
+		return
+	}
+
+	if _, exist := p.watchingNamespace[namespace]; exist {
+		err = fmt.Errorf("redconf: namespace of %s already in watching", namespace)
+		return
+	}
+
+	var snapshot *fileSnapshot
+	if snapshot, err = p.load(); err != nil {
+		return
+	}
+
+	stopC := make(chan struct{})
+
+	p.watchingNamespace[namespace] = stopC
+
+	go p.watchNamespace(namespace, stopC, snapshot, callback)
+
+	return
+}
+
+func (p *FileMonitor) Unwatch(namespace string) (err error) {
+
+	p.watchLocker.Lock()
+	defer p.watchLocker.Unlock()
+
+	if stopC, exist := p.watchingNamespace[namespace]; exist {
+		delete(p.watchingNamespace, namespace)
+		close(stopC)
+	}
+
+	return
+}
+
+func (p *FileMonitor) Close() (err error) {
+
+	p.watchLocker.Lock()
+	defer p.watchLocker.Unlock()
+
+	if p.closed {
+		return
+	}
+
+	p.closed = true
+
+	for namespace, stopC := range p.watchingNamespace {
+		delete(p.watchingNamespace, namespace)
+		close(stopC)
+	}
+
+	return
+}
+
+// watchNamespace keep the last good document while the file could not be read or parsed,
+// such as the file is writing or replacing
+func (p *FileMonitor) watchNamespace(namespace string, stopC chan struct{}, snapshot *fileSnapshot, callback KeyContentChangedCallback) {
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopC:
+			return
+		case <-ticker.C:
+		}
+
+		fi, err := os.Stat(p.filename)
+		if err != nil {
+			continue
+		}
+
+		if fi.ModTime().Equal(snapshot.modTime) && fi.Size() == snapshot.size {
+			continue
+		}
+
+		newSnapshot, err := p.load()
+		if err != nil {
+			continue
+		}
+
+		changedKeys := diffFileSnapshot(snapshot, newSnapshot)
+
+		snapshot = newSnapshot
+
+		if callback == nil || len(changedKeys) == 0 {
+			continue
+		}
+
+		select {
+		case <-stopC:
+			return
+		default:
+		}
+
+		// the keys changed by one edit are applied as one change set
+		callback(namespace, changedKeys...)
+	}
+}
+
+func (p *FileMonitor) load() (snapshot *fileSnapshot, err error) {
+
+	var fi os.FileInfo
+	if fi, err = os.Stat(p.filename); err != nil {
+		return
+	}
+
+	var doc map[string]interface{}
+	if doc, err = loadFileDocument(p.filename, p.format); err != nil {
+		return
+	}
+
+	kv := make(map[string]string)
+
+	flattenDocument(p.configName, doc, kv)
+
+	snapshot = &fileSnapshot{
+		kv:      kv,
+		modTime: fi.ModTime(),
+		size:    fi.Size(),
+	}
+
+	return
+}
+
+func diffFileSnapshot(old, new *fileSnapshot) (changedKeys []string) {
+
+	for k, v := range new.kv {
+		if oldV, exist := old.kv[k]; !exist || oldV != v {
+			changedKeys = append(changedKeys, k)
+		}
+	}
+
+	for k := range old.kv {
+		if _, exist := new.kv[k]; !exist {
+			changedKeys = append(changedKeys, k)
+		}
+	}
+
+	sort.Strings(changedKeys)
+
+	return
+}
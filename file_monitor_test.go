@@ -0,0 +1,144 @@
+package redconf
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type fileTestServerConfig struct {
+	Host string
+	Port int
+}
+
+type fileTestConfig struct {
+	Server fileTestServerConfig
+}
+
+func TestFileMonitorWatch(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "redconf")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "AppConfig.json")
+
+	if err = ioutil.WriteFile(filename, []byte(`{"Server": {"Host": "127.0.0.1", "Port": 8080}}`), 0644); err != nil {
+		t.Error(err)
+		return
+	}
+
+	var monitor Monitor
+	if monitor, err = CreateMonitor("file", Options{"filename": filename, "interval": "10ms"}); err != nil {
+		t.Error(err)
+		return
+	}
+	defer monitor.Close()
+
+	changedC := make(chan []string, 10)
+
+	if err = monitor.Watch("NS", func(namespace string, keys ...string) { changedC <- keys }, nil); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err = ioutil.WriteFile(filename, []byte(`{"Server": {"Host": "127.0.0.1", "Port": 18080}}`), 0644); err != nil {
+		t.Error(err)
+		return
+	}
+
+	expectedKeys := []string{"AppConfig:Server", "AppConfig:Server:Port"}
+
+	select {
+	case keys := <-changedC:
+		if !reflect.DeepEqual(keys, expectedKeys) {
+			t.Errorf("changed keys excepted: %v, got: %v", expectedKeys, keys)
+			return
+		}
+	case <-time.After(time.Second):
+		t.Errorf("wait changed keys of %v timeout", expectedKeys)
+		return
+	}
+
+	select {
+	case keys := <-changedC:
+		t.Errorf("unexcepted changed keys: %v", keys)
+	case <-time.After(time.Millisecond * 50):
+	}
+}
+
+func TestFileMonitorRedConf(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "redconf")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "fileTestConfig.yaml")
+
+	if err = ioutil.WriteFile(filename, []byte("Server:\n  Host: 127.0.0.1\n  Port: 8080\n"), 0644); err != nil {
+		t.Error(err)
+		return
+	}
+
+	opts := Options{"filename": filename, "interval": "10ms"}
+
+	var storage Storage
+	var monitor Monitor
+
+	if storage, err = CreateStorage("file", opts); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if monitor, err = CreateMonitor("file", opts); err != nil {
+		t.Error(err)
+		return
+	}
+	defer monitor.Close()
+
+	var redConf *RedConf
+	if redConf, err = New("", storage, monitor); err != nil {
+		t.Error(err)
+		return
+	}
+	defer redConf.Close()
+
+	conf := fileTestConfig{}
+
+	if err = redConf.Watch(&conf); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if conf.Server.Host != "127.0.0.1" || conf.Server.Port != 8080 {
+		t.Errorf("sync config failed: %#v", conf)
+		return
+	}
+
+	changedC := make(chan OnValueChangedEvent, 10)
+
+	redConf.Subscribe(func(event OnValueChangedEvent) { changedC <- event })
+
+	if err = storage.Set("", "fileTestConfig:Server:Port", 18080); err != nil {
+		t.Error(err)
+		return
+	}
+
+	select {
+	case event := <-changedC:
+		if event.Key != "fileTestConfig:Server:Port" || event.AfterValue != 18080 {
+			t.Errorf("unexcepted event: %#v", event)
+		}
+	case <-time.After(time.Second):
+		t.Error("wait value changed event timeout")
+	}
+}
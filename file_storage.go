@@ -0,0 +1,410 @@
+package redconf
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	_ Storage      = (*FileStorage)(nil)
+	_ BatchStorage = (*FileStorage)(nil)
+	_ ScanStorage  = (*FileStorage)(nil)
+)
+
+const (
+	FileFormatJSON = "json"
+	FileFormatYAML = "yaml"
+	FileFormatTOML = "toml"
+)
+
+// FileStorage serve the values from a local JSON, YAML or TOML document,
+// the document is the same as the file of cmd/json2redis, the key of
+// AppConfig:Server:Port is mapping to {"Server": {"Port": 8080}} in AppConfig.json,
+// the namespace is ignored
+type FileStorage struct {
+	filename   string
+	format     string
+	configName string
+
+	doc     map[string]interface{}
+	kv      map[string]string
+	modTime time.Time
+	size    int64
+	locker  sync.Mutex
+}
+
+func init() {
+	RegisterStorage("file", NewFileStorage)
+}
+
+func NewFileStorage(opts Options) (storage Storage, err error) {
+
+	filename := ""
+	format := ""
+	configName := ""
+
+	opts.Get("filename", &filename)
+	opts.Get("format", &format)
+
+	if filename == "" {
+		err = fmt.Errorf("redconf: the option of filename is empty")
+		return
+	}
+
+	if format, err = fileFormat(filename, format); err != nil {
+		return
+	}
+
+	if exist := opts.Get("config_name", &configName); !exist {
+		configName = fileConfigName(filename)
+	}
+
+	s := &FileStorage{
+		filename:   filename,
+		format:     format,
+		configName: configName,
+	}
+
+	if err = s.reload(); err != nil {
+		return
+	}
+
+	storage = s
+
+	return
+}
+
+func (p *FileStorage) Set(namespace, key string, val interface{}) (err error) {
+
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	if err = p.reload(); err != nil {
+		return
+	}
+
+	path := strings.Split(key, ":")
+
+	if p.configName != "" {
+		if path[0] != p.configName {
+			err = fmt.Errorf("redconf: the key of %s is not belong to config %s", key, p.configName)
+			return
+		}
+		path = path[1:]
+	}
+
+	if len(path) == 0 {
+		err = fmt.Errorf("redconf: the key of %s is not a value", key)
+		return
+	}
+
+	if p.doc == nil {
+		p.doc = make(map[string]interface{})
+	}
+
+	node := p.doc
+
+	for _, name := range path[:len(path)-1] {
+		next, ok := node[name].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			node[name] = next
+		}
+		node = next
+	}
+
+	node[path[len(path)-1]] = fmt.Sprintf("%v", val)
+
+	if err = writeFileDocument(p.filename, p.format, p.doc); err != nil {
+		return
+	}
+
+	// force reload for updating kv, modTime and size
+	p.modTime = time.Time{}
+
+	err = p.reload()
+
+	return
+}
+
+func (p *FileStorage) Get(namespace, key string) (ret interface{}, err error) {
+
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	if err = p.reload(); err != nil {
+		return
+	}
+
+	if v, exist := p.kv[key]; exist {
+		ret = v
+	}
+
+	return
+}
+
+func (p *FileStorage) GetMulti(namespace string, keys []string) (rets []interface{}, err error) {
+
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	if err = p.reload(); err != nil {
+		return
+	}
+
+	for _, key := range keys {
+		var ret interface{}
+		if v, exist := p.kv[key]; exist {
+			ret = v
+		}
+		rets = append(rets, ret)
+	}
+
+	return
+}
+
+func (p *FileStorage) Scan(namespace, prefix string) (keys []string, err error) {
+
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	if err = p.reload(); err != nil {
+		return
+	}
+
+	for key := range p.kv {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+
+	return
+}
+
+func (p *FileStorage) reload() (err error) {
+
+	var fi os.FileInfo
+	if fi, err = os.Stat(p.filename); err != nil {
+		return
+	}
+
+	if fi.ModTime().Equal(p.modTime) && fi.Size() == p.size {
+		return
+	}
+
+	var doc map[string]interface{}
+	if doc, err = loadFileDocument(p.filename, p.format); err != nil {
+		return
+	}
+
+	kv := make(map[string]string)
+
+	flattenDocument(p.configName, doc, kv)
+
+	p.doc = doc
+	p.kv = kv
+	p.modTime = fi.ModTime()
+	p.size = fi.Size()
+
+	return
+}
+
+func fileFormat(filename, format string) (ret string, err error) {
+
+	if format == "" {
+		format = strings.TrimPrefix(filepath.Ext(filename), ".")
+	}
+
+	switch strings.ToLower(format) {
+	case "json":
+		ret = FileFormatJSON
+	case "yaml", "yml":
+		ret = FileFormatYAML
+	case "toml":
+		ret = FileFormatTOML
+	default:
+		err = fmt.Errorf("redconf: unknown file format of %s", filename)
+	}
+
+	return
+}
+
+func fileConfigName(filename string) string {
+	name := filepath.Base(filename)
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+func loadFileDocument(filename, format string) (doc map[string]interface{}, err error) {
+
+	var data []byte
+	if data, err = ioutil.ReadFile(filename); err != nil {
+		return
+	}
+
+	doc = make(map[string]interface{})
+
+	switch format {
+	case FileFormatJSON:
+		{
+			decoder := json.NewDecoder(bytes.NewBuffer(data))
+			decoder.UseNumber()
+
+			err = decoder.Decode(&doc)
+		}
+	case FileFormatYAML:
+		{
+			yamlDoc := make(map[interface{}]interface{})
+			if err = yaml.Unmarshal(data, &yamlDoc); err != nil {
+				return
+			}
+
+			doc = normalizeYAMLValue(yamlDoc).(map[string]interface{})
+		}
+	case FileFormatTOML:
+		{
+			_, err = toml.Decode(string(data), &doc)
+		}
+	default:
+		err = fmt.Errorf("redconf: unknown file format of %s", format)
+	}
+
+	if err != nil {
+		err = fmt.Errorf("redconf: parse file %s failure, %s", filename, err)
+	}
+
+	return
+}
+
+func writeFileDocument(filename, format string, doc map[string]interface{}) (err error) {
+
+	var data []byte
+
+	switch format {
+	case FileFormatJSON:
+		data, err = json.MarshalIndent(doc, "", "    ")
+	case FileFormatYAML:
+		data, err = yaml.Marshal(doc)
+	case FileFormatTOML:
+		{
+			buf := bytes.NewBuffer(nil)
+			err = toml.NewEncoder(buf).Encode(doc)
+			data = buf.Bytes()
+		}
+	default:
+		err = fmt.Errorf("redconf: unknown file format of %s", format)
+	}
+
+	if err != nil {
+		return
+	}
+
+	tmpFilename := filename + ".tmp"
+
+	if err = ioutil.WriteFile(tmpFilename, data, 0644); err != nil {
+		return
+	}
+
+	err = os.Rename(tmpFilename, filename)
+
+	return
+}
+
+func normalizeYAMLValue(v interface{}) interface{} {
+	switch typedV := v.(type) {
+	case map[interface{}]interface{}:
+		{
+			m := make(map[string]interface{}, len(typedV))
+			for k, item := range typedV {
+				m[fmt.Sprintf("%v", k)] = normalizeYAMLValue(item)
+			}
+			return m
+		}
+	case []interface{}:
+		{
+			for i := 0; i < len(typedV); i++ {
+				typedV[i] = normalizeYAMLValue(typedV[i])
+			}
+		}
+	}
+
+	return v
+}
+
+// flattenDocument flatten the document to the keys as cmd/json2redis does,
+// the objects are also stored as JSON for map and struct fields, and the lists are
+// stored as JSON array, so the elements could contain comma or the sep of tag
+func flattenDocument(prefix string, doc map[string]interface{}, kv map[string]string) {
+	for k, item := range doc {
+		key := k
+		if prefix != "" {
+			key = prefix + ":" + k
+		}
+		flattenValue(key, item, kv)
+	}
+}
+
+func flattenValue(prefix string, v interface{}, kv map[string]string) {
+
+	switch typedV := v.(type) {
+	case map[string]interface{}:
+		{
+			if data, err := json.Marshal(typedV); err == nil {
+				kv[prefix] = string(data)
+			}
+
+			flattenDocument(prefix, typedV, kv)
+		}
+	case []interface{}:
+		{
+			// the lists are stored as JSON array, so the elements could contain the sep
+			strVs := []string{}
+			for _, item := range typedV {
+				switch item.(type) {
+				case map[string]interface{}, []interface{}, []map[string]interface{}:
+					{
+						if data, err := json.Marshal(typedV); err == nil {
+							kv[prefix] = string(data)
+						}
+						return
+					}
+				}
+				strVs = append(strVs, fileScalarString(item))
+			}
+
+			if data, err := json.Marshal(strVs); err == nil {
+				kv[prefix] = string(data)
+			}
+		}
+	case []map[string]interface{}:
+		{
+			if data, err := json.Marshal(typedV); err == nil {
+				kv[prefix] = string(data)
+			}
+		}
+	default:
+		kv[prefix] = fileScalarString(v)
+	}
+}
+
+func fileScalarString(v interface{}) string {
+	switch typedV := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return typedV
+	case time.Time:
+		return typedV.Format(time.RFC3339Nano)
+	}
+
+	return fmt.Sprintf("%v", v)
+}
@@ -0,0 +1,142 @@
+package redconf
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var fileStorageTestDocs = map[string]string{
+	"AppConfig.json": `{
+	"Server": {"Host": "127.0.0.1", "Port": 8080, "AllowIPs": ["127.0.0.1", "10.0.0.1"]},
+	"Log": {"Path": "/var/log/app.log"}
+}`,
+	"AppConfig.yaml": `
+Server:
+  Host: 127.0.0.1
+  Port: 8080
+  AllowIPs:
+    - 127.0.0.1
+    - 10.0.0.1
+Log:
+  Path: /var/log/app.log
+`,
+	"AppConfig.toml": `
+[Server]
+Host = "127.0.0.1"
+Port = 8080
+AllowIPs = ["127.0.0.1", "10.0.0.1"]
+
+[Log]
+Path = "/var/log/app.log"
+`,
+}
+
+func TestFileStorageGet(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "redconf")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	expected := map[string]interface{}{
+		"AppConfig:Server:Host":     "127.0.0.1",
+		"AppConfig:Server:Port":     "8080",
+		"AppConfig:Server:AllowIPs": `["127.0.0.1","10.0.0.1"]`,
+		"AppConfig:Log:Path":        "/var/log/app.log",
+		"AppConfig:Log:Maxsize":     nil,
+	}
+
+	for name, content := range fileStorageTestDocs {
+		filename := filepath.Join(dir, name)
+
+		if err = ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Error(err)
+			return
+		}
+
+		var storage Storage
+		if storage, err = CreateStorage("file", Options{"filename": filename}); err != nil {
+			t.Error(err)
+			return
+		}
+
+		for key, expectedVal := range expected {
+			var val interface{}
+			if val, err = storage.Get("", key); err != nil {
+				t.Error(err)
+				return
+			}
+
+			if val != expectedVal {
+				t.Errorf("%s: get value of %s failed, excepted: %v, got: %v", name, key, expectedVal, val)
+			}
+		}
+
+		if err = storage.Set("", "AppConfig:Log:Maxsize", 1024); err != nil {
+			t.Error(err)
+			return
+		}
+
+		var val interface{}
+		if val, err = storage.Get("", "AppConfig:Log:Maxsize"); err != nil {
+			t.Error(err)
+			return
+		}
+
+		if val != "1024" {
+			t.Errorf("%s: set value failed, got: %v", name, val)
+		}
+	}
+}
+
+func TestFileStorageList(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "redconf")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "AppConfig.yaml")
+
+	content := "Hosts: [a, b]\nDSNs: [\"x=1,y=2\", z]\n"
+
+	if err = ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+		t.Error(err)
+		return
+	}
+
+	storage, err := CreateStorage("file", Options{"filename": filename})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	cases := []struct {
+		key      string
+		opts     convOptions
+		excepted []string
+	}{
+		{"AppConfig:Hosts", convOptions{sep: ";"}, []string{"a", "b"}},
+		{"AppConfig:DSNs", convOptions{}, []string{"x=1,y=2", "z"}},
+	}
+
+	for _, c := range cases {
+		val, err := storage.Get("", c.key)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		v, err := conv(reflect.TypeOf([]string{}), val, c.opts)
+		if err != nil || !reflect.DeepEqual(v, c.excepted) {
+			t.Errorf("conv the list of %s failed, excepted: %v, got: %v, %v", c.key, c.excepted, v, err)
+		}
+	}
+}
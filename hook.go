@@ -0,0 +1,87 @@
+package redconf
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ConfigChangedHook could be implemented by the watched struct and its nested structs,
+// OnConfigChanged is called with the changed keys under the struct after they applied,
+// the nested struct is called before its parents. in copy on write mode, it is called
+// on the new snapshot
+type ConfigChangedHook interface {
+	OnConfigChanged(changedKeys []string)
+}
+
+type configHook struct {
+	hook        ConfigChangedHook
+	changedKeys []string
+}
+
+// collectHooks find the hooks of the structs which the changes are under,
+// it should be called after the changes applied to the config
+func collectHooks(conf *WatchingConfig, changes []*fieldChange) (hooks []configHook) {
+
+	var paths [][]string
+	pathKeys := make(map[string][]string)
+
+	for _, change := range changes {
+		parents := change.field.parents
+		for i := len(parents); i >= 0; i-- {
+			pathName := strings.Join(parents[:i], ".")
+			if _, exist := pathKeys[pathName]; !exist {
+				paths = append(paths, parents[:i])
+			}
+			for _, event := range change.events() {
+				pathKeys[pathName] = append(pathKeys[pathName], event.Key)
+			}
+		}
+	}
+
+	sort.Slice(paths, func(i, j int) bool {
+		if len(paths[i]) != len(paths[j]) {
+			return len(paths[i]) > len(paths[j])
+		}
+		return strings.Join(paths[i], ".") < strings.Join(paths[j], ".")
+	})
+
+	root := conf.root()
+
+	for _, path := range paths {
+		val := structPointer(root, path)
+		if !val.IsValid() || !val.CanInterface() {
+			continue
+		}
+
+		if hook, ok := val.Interface().(ConfigChangedHook); ok {
+			hooks = append(hooks, configHook{
+				hook:        hook,
+				changedKeys: pathKeys[strings.Join(path, ".")],
+			})
+		}
+	}
+
+	return
+}
+
+// structPointer return the pointer of the nested struct by the field names
+func structPointer(root reflect.Value, path []string) reflect.Value {
+
+	val := root
+
+	for _, name := range path {
+		fieldVal := val.Elem().FieldByName(name)
+
+		if fieldVal.Kind() == reflect.Ptr {
+			if fieldVal.IsNil() {
+				return reflect.Value{}
+			}
+			val = fieldVal
+		} else {
+			val = fieldVal.Addr()
+		}
+	}
+
+	return val
+}
@@ -0,0 +1,224 @@
+package redconf
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// checkMapFields check the storage could get the entries of the map fields in keys or hash mode
+func (p *RedConf) checkMapFields(conf *WatchingConfig) (err error) {
+
+	for _, field := range conf.fields {
+		switch field.convOptions.mapMode {
+		case MapModeKeys:
+			if _, ok := p.storage.(ScanStorage); !ok {
+				err = fmt.Errorf("redconf: the storage could not scan the keys of map field %s", field.String())
+				return
+			}
+		case MapModeHash:
+			if _, ok := p.storage.(HashStorage); !ok {
+				err = fmt.Errorf("redconf: the storage could not get the hash of map field %s", field.String())
+				return
+			}
+		}
+	}
+
+	return
+}
+
+// mapFieldKeys is the map field of the changed keys, the entries of changed sub keys
+// are got and merged to the entries got before, all entries are got while the key
+// of map itself is changed
+type mapFieldKeys struct {
+	field   *Field
+	all     bool
+	subKeys []string
+}
+
+func (p *mapFieldKeys) merge(other *mapFieldKeys) {
+	p.all = p.all || other.all
+	p.subKeys = append(p.subKeys, other.subKeys...)
+}
+
+// lookupKeys map the changed keys to the watching keys, the sub key of map field like
+// AppConfig:Limits:tenant_a is mapped to AppConfig:Limits, the other keys are kept,
+// it should be called with the confLock held
+func (p *RedConf) lookupKeys(keys []string) (watchingKeys []string, mapFields map[string]*mapFieldKeys) {
+
+	mapFields = make(map[string]*mapFieldKeys)
+	found := make(map[string]bool, len(keys))
+
+	for _, key := range keys {
+
+		subKey := ""
+		field, exist := p.watchingKeyIndex[key]
+
+		if !exist {
+			if idx := strings.LastIndex(key, ":"); idx > 0 {
+				if parent, ok := p.watchingKeyIndex[key[:idx]]; ok && parent.convOptions.mapMode != "" {
+					field, exist = parent, true
+					subKey, key = key, key[:idx]
+				}
+			}
+		}
+
+		if exist && field.convOptions.mapMode != "" {
+			mapField, ok := mapFields[key]
+			if !ok {
+				mapField = &mapFieldKeys{field: field}
+				mapFields[key] = mapField
+			}
+
+			if subKey == "" {
+				mapField.all = true
+			} else {
+				mapField.subKeys = append(mapField.subKeys, subKey)
+			}
+		}
+
+		if found[key] {
+			continue
+		}
+
+		found[key] = true
+		watchingKeys = append(watchingKeys, key)
+	}
+
+	return
+}
+
+// getMapEntries get the raw values of the entries, it is nil while there is no entry,
+// so the default value and required of tag works as the other fields.
+// in keys mode, all entries are got by SCAN which walks the whole keyspace, so only
+// the changed sub keys are got and merged to the entries got before, and SCAN is
+// called while watching, resyncing, or the key of map itself is changed
+func (p *RedConf) getMapEntries(mapField *mapFieldKeys) (entries map[string]interface{}, err error) {
+
+	field := mapField.field
+	key := field.String()
+
+	if field.convOptions.mapMode == MapModeHash {
+		entries, err = p.storage.(HashStorage).GetHash(p.namespace, key)
+		return
+	}
+
+	p.mapEntriesLock.Lock()
+	cached, exist := p.mapEntries[key]
+	p.mapEntriesLock.Unlock()
+
+	var entryKeys []string
+
+	if mapField.all || !exist {
+		var subKeys []string
+		if subKeys, err = p.storage.(ScanStorage).Scan(p.namespace, key+":"); err != nil {
+			return
+		}
+
+		// the deeper keys are not the entries, such as the keys of the struct in JSON
+		for _, subKey := range subKeys {
+			if name := strings.TrimPrefix(subKey, key+":"); name != "" && !strings.Contains(name, ":") {
+				entryKeys = append(entryKeys, subKey)
+			}
+		}
+
+		cached = nil
+	} else {
+		entryKeys = mapField.subKeys
+	}
+
+	merged := make(map[string]interface{}, len(cached)+len(entryKeys))
+	for name, val := range cached {
+		merged[name] = val
+	}
+
+	if len(entryKeys) > 0 {
+		var kvs map[string]interface{}
+		if kvs, err = p.getValues(entryKeys, nil); err != nil {
+			return
+		}
+
+		for subKey, val := range kvs {
+			name := strings.TrimPrefix(subKey, key+":")
+
+			if val == nil {
+				delete(merged, name)
+				continue
+			}
+
+			merged[name] = val
+		}
+	}
+
+	p.mapEntriesLock.Lock()
+	p.mapEntries[key] = merged
+	p.mapEntriesLock.Unlock()
+
+	if len(merged) == 0 {
+		return
+	}
+
+	entries = make(map[string]interface{}, len(merged))
+	for name, val := range merged {
+		entries[name] = val
+	}
+
+	return
+}
+
+// mapEntryEvents split the changed map into the events of the changed entries,
+// the key of event is the sub key like AppConfig:Limits:tenant_a, and the value of
+// the added or removed entry is nil
+func mapEntryEvents(event OnValueChangedEvent) (events []OnValueChangedEvent) {
+
+	before := reflect.ValueOf(event.BeforeValue)
+	after := reflect.ValueOf(event.AfterValue)
+
+	names := make(map[string]reflect.Value)
+
+	for _, m := range []reflect.Value{before, after} {
+		if m.Kind() != reflect.Map {
+			continue
+		}
+
+		for _, k := range m.MapKeys() {
+			names[k.String()] = k
+		}
+	}
+
+	for name, k := range names {
+
+		beforeVal := mapIndex(before, k)
+		afterVal := mapIndex(after, k)
+
+		if valueEqual(beforeVal, afterVal) {
+			continue
+		}
+
+		entryEvent := event
+		entryEvent.Key = event.Key + ":" + name
+		entryEvent.BeforeValue = beforeVal
+		entryEvent.AfterValue = afterVal
+
+		events = append(events, entryEvent)
+	}
+
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].Key < events[j].Key
+	})
+
+	return
+}
+
+func mapIndex(m, k reflect.Value) interface{} {
+	if m.Kind() != reflect.Map {
+		return nil
+	}
+
+	if v := m.MapIndex(k); v.IsValid() {
+		return v.Interface()
+	}
+
+	return nil
+}
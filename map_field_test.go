@@ -0,0 +1,198 @@
+package redconf
+
+import (
+	"reflect"
+	"testing"
+)
+
+type TestMapFieldConfig struct {
+	Limits  map[string]int    `redconf:"limits,map=keys"`
+	Tenants map[string]string `redconf:"tenants,map=hash,default={\"default\":\"shared\"}"`
+}
+
+func TestMapFieldByKeys(t *testing.T) {
+
+	redConf, storage := newMemoryRedConf(t, "NS")
+	defer redConf.Close()
+
+	storage.Set("NS", "TestMapFieldConfig:limits:tenant_a", "10")
+	storage.Set("NS", "TestMapFieldConfig:limits:tenant_b", "20")
+	storage.Set("NS", "TestMapFieldConfig:limits:tenant_b:ignored", "30")
+
+	conf := TestMapFieldConfig{}
+
+	if err := redConf.Watch(&conf); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !reflect.DeepEqual(conf.Limits, map[string]int{"tenant_a": 10, "tenant_b": 20}) {
+		t.Errorf("sync the map by keys failed: %#v", conf.Limits)
+		return
+	}
+
+	if !reflect.DeepEqual(conf.Tenants, map[string]string{"default": "shared"}) {
+		t.Errorf("the default value of map in hash mode should be used: %#v", conf.Tenants)
+		return
+	}
+
+	var events []OnValueChangedEvent
+	redConf.SubscribePrefix("TestMapFieldConfig:limits", func(event OnValueChangedEvent) {
+		events = append(events, event)
+	})
+
+	var keyEvents []OnValueChangedEvent
+	redConf.SubscribeKey("TestMapFieldConfig:limits:tenant_c", func(event OnValueChangedEvent) {
+		keyEvents = append(keyEvents, event)
+	})
+
+	storage.Set("NS", "TestMapFieldConfig:limits:tenant_a", "15")
+	storage.Set("NS", "TestMapFieldConfig:limits:tenant_c", "5")
+	storage.Delete("NS", "TestMapFieldConfig:limits:tenant_b")
+
+	if !reflect.DeepEqual(conf.Limits, map[string]int{"tenant_a": 15, "tenant_c": 5}) {
+		t.Errorf("update the entries of map failed: %#v", conf.Limits)
+		return
+	}
+
+	if len(events) != 3 {
+		t.Errorf("the events of entries excepted 3, got: %#v", events)
+		return
+	}
+
+	if events[0].Key != "TestMapFieldConfig:limits:tenant_a" || events[0].BeforeValue != 10 || events[0].AfterValue != 15 {
+		t.Errorf("the event of changed entry is wrong: %#v", events[0])
+	}
+
+	if events[2].Key != "TestMapFieldConfig:limits:tenant_b" || events[2].BeforeValue != 20 || events[2].AfterValue != nil {
+		t.Errorf("the event of removed entry is wrong: %#v", events[2])
+	}
+
+	if len(keyEvents) != 1 || keyEvents[0].BeforeValue != nil || keyEvents[0].AfterValue != 5 {
+		t.Errorf("the event of added entry is wrong: %#v", keyEvents)
+	}
+}
+
+func TestMapFieldByHash(t *testing.T) {
+
+	redConf, storage := newMemoryRedConf(t, "NS")
+	defer redConf.Close()
+
+	storage.Set("NS", "TestMapFieldConfig:tenants", map[string]string{"a": "db1", "b": "db2"})
+
+	conf := TestMapFieldConfig{}
+
+	if err := redConf.Watch(&conf); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !reflect.DeepEqual(conf.Tenants, map[string]string{"a": "db1", "b": "db2"}) {
+		t.Errorf("sync the map by hash failed: %#v", conf.Tenants)
+		return
+	}
+
+	var changedKeys []string
+	redConf.SubscribeChangeSet(func(changeSet ChangeSet) {
+		changedKeys = append(changedKeys, changeSet.Keys()...)
+	})
+
+	storage.Set("NS", "TestMapFieldConfig:tenants", map[string]string{"a": "db1", "b": "db3"})
+
+	if conf.Tenants["b"] != "db3" || !reflect.DeepEqual(changedKeys, []string{"TestMapFieldConfig:tenants:b"}) {
+		t.Errorf("update the hash failed: %#v, changed keys: %v", conf.Tenants, changedKeys)
+		return
+	}
+}
+
+type testScanCountStorage struct {
+	*MemoryStorage
+	scans int
+}
+
+func (p *testScanCountStorage) Scan(namespace, prefix string) (keys []string, err error) {
+	p.scans++
+	return p.MemoryStorage.Scan(namespace, prefix)
+}
+
+func TestMapFieldByKeysWithoutScan(t *testing.T) {
+
+	opts := Options{"bucket": newTestBucket(t)}
+
+	memStorage, _ := CreateStorage("memory", opts)
+	monitor, _ := CreateMonitor("memory", opts)
+
+	storage := &testScanCountStorage{MemoryStorage: memStorage.(*MemoryStorage)}
+
+	redConf, err := New("NS", storage, monitor, Options{"queue_size": 0})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer redConf.Close()
+
+	storage.Set("NS", "TestMapFieldConfig:limits:tenant_a", "10")
+	storage.Set("NS", "TestMapFieldConfig:limits:tenant_b", "20")
+
+	conf := TestMapFieldConfig{}
+
+	if err = redConf.Watch(&conf); err != nil {
+		t.Error(err)
+		return
+	}
+
+	storage.Set("NS", "TestMapFieldConfig:limits:tenant_a", "15")
+	storage.Set("NS", "TestMapFieldConfig:limits:tenant_c", "5")
+	storage.Delete("NS", "TestMapFieldConfig:limits:tenant_b")
+
+	if !reflect.DeepEqual(conf.Limits, map[string]int{"tenant_a": 15, "tenant_c": 5}) {
+		t.Errorf("merge the changed entries failed: %#v", conf.Limits)
+		return
+	}
+
+	if storage.scans != 1 {
+		t.Errorf("the entries should be scanned only while watching, scanned %d times", storage.scans)
+		return
+	}
+
+	// the entry changed without notification is found by resync
+	storage.bucket.locker.Lock()
+	storage.bucket.values["NS:TestMapFieldConfig:limits:tenant_d"] = "1"
+	storage.bucket.locker.Unlock()
+
+	redConf.dispatcher.requestResync()
+
+	if !reflect.DeepEqual(conf.Limits, map[string]int{"tenant_a": 15, "tenant_c": 5, "tenant_d": 1}) || storage.scans != 2 {
+		t.Errorf("resync should scan the entries, got: %#v, scanned %d times", conf.Limits, storage.scans)
+	}
+}
+
+type TestMapFieldBadConfig struct {
+	Limits map[int]int `redconf:"limits,map=keys"`
+}
+
+func TestMapFieldValidate(t *testing.T) {
+
+	if _, err := NewWatchingConfig(&TestMapFieldBadConfig{}); err == nil {
+		t.Error("the map with key of int in keys mode should be failed")
+	}
+
+	opts := Options{"bucket": newTestBucket(t)}
+
+	memStorage, _ := CreateStorage("memory", opts)
+	monitor, _ := CreateMonitor("memory", opts)
+
+	// hide the Scan and GetHash of MemoryStorage
+	storage := struct{ Storage }{memStorage}
+
+	redConf, err := New("NS", storage, monitor, Options{"queue_size": 0})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer redConf.Close()
+
+	if err = redConf.Watch(&TestMapFieldConfig{}); err == nil {
+		t.Error("the storage which could not scan the keys should be failed")
+	}
+}
@@ -0,0 +1,93 @@
+package redconf
+
+import (
+	"fmt"
+	"sync"
+)
+
+var (
+	_ Monitor = (*MemoryMonitor)(nil)
+)
+
+// MemoryMonitor is notified by the MemoryStorage of the same bucket,
+// the callback is called synchronously in the Set of storage
+type MemoryMonitor struct {
+	bucket *memoryBucket
+
+	watchingNamespace map[string]*memoryWatcher
+	watchLocker       sync.Mutex
+	closed            bool
+}
+
+func init() {
+	RegisterMonitor("memory", NewMemoryMonitor)
+}
+
+func NewMemoryMonitor(opts Options) (monitor Monitor, err error) {
+
+	bucket := ""
+
+	opts.Get("bucket", &bucket)
+
+	monitor = &MemoryMonitor{
+		bucket:            getMemoryBucket(bucket),
+		watchingNamespace: make(map[string]*memoryWatcher),
+	}
+
+	return
+}
+
+func (p *MemoryMonitor) Watch(namespace string, callback KeyContentChangedCallback, onError OnWatchingError) (err error) {
+
+	p.watchLocker.Lock()
+	defer p.watchLocker.Unlock()
+
+	if p.closed {
+		err = fmt.Errorf("redconf: memory monitor already closed")
+		return
+	}
+
+	if _, exist := p.watchingNamespace[namespace]; exist {
+		err = fmt.Errorf("redconf: namespace of %s already in watching", namespace)
+		return
+	}
+
+	watcher := &memoryWatcher{callback: callback}
+
+	p.watchingNamespace[namespace] = watcher
+	p.bucket.addWatcher(namespace, watcher)
+
+	return
+}
+
+func (p *MemoryMonitor) Unwatch(namespace string) (err error) {
+
+	p.watchLocker.Lock()
+	defer p.watchLocker.Unlock()
+
+	if watcher, exist := p.watchingNamespace[namespace]; exist {
+		delete(p.watchingNamespace, namespace)
+		p.bucket.removeWatcher(namespace, watcher)
+	}
+
+	return
+}
+
+func (p *MemoryMonitor) Close() (err error) {
+
+	p.watchLocker.Lock()
+	defer p.watchLocker.Unlock()
+
+	if p.closed {
+		return
+	}
+
+	p.closed = true
+
+	for namespace, watcher := range p.watchingNamespace {
+		delete(p.watchingNamespace, namespace)
+		p.bucket.removeWatcher(namespace, watcher)
+	}
+
+	return
+}
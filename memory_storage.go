@@ -0,0 +1,236 @@
+package redconf
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+var (
+	_ Storage      = (*MemoryStorage)(nil)
+	_ BatchStorage = (*MemoryStorage)(nil)
+	_ ScanStorage  = (*MemoryStorage)(nil)
+	_ HashStorage  = (*MemoryStorage)(nil)
+)
+
+const (
+	DefaultMemoryBucket = "default"
+)
+
+var (
+	memoryBuckets = make(map[string]*memoryBucket)
+
+	memoryBucketsLocker sync.Mutex
+)
+
+// memoryBucket is shared by the memory storage and monitor which created with the same bucket option,
+// the Set of storage will callback the monitors which watching the namespace
+type memoryBucket struct {
+	values map[string]interface{}
+	locker sync.RWMutex
+
+	watchers       map[string]map[*memoryWatcher]bool
+	watchersLocker sync.Mutex
+}
+
+type memoryWatcher struct {
+	callback KeyContentChangedCallback
+}
+
+// MemoryStorage keep the values in memory, it is useful for tests and embedded use
+type MemoryStorage struct {
+	bucket *memoryBucket
+}
+
+func init() {
+	RegisterStorage("memory", NewMemoryStorage)
+}
+
+func NewMemoryStorage(opts Options) (storage Storage, err error) {
+
+	bucket := ""
+
+	opts.Get("bucket", &bucket)
+
+	storage = &MemoryStorage{
+		bucket: getMemoryBucket(bucket),
+	}
+
+	return
+}
+
+func (p *MemoryStorage) getMemoryKey(namespace, key string) string {
+	if namespace == "" {
+		return key
+	}
+
+	return namespace + ":" + key
+}
+
+func (p *MemoryStorage) Set(namespace, key string, val interface{}) (err error) {
+
+	p.bucket.locker.Lock()
+	p.bucket.values[p.getMemoryKey(namespace, key)] = val
+	p.bucket.locker.Unlock()
+
+	p.bucket.notify(namespace, key)
+
+	return
+}
+
+// SetMulti set the values together and notify the monitors once,
+// the values will be applied as one change set
+func (p *MemoryStorage) SetMulti(namespace string, kvs map[string]interface{}) (err error) {
+
+	var keys []string
+
+	p.bucket.locker.Lock()
+	for key, val := range kvs {
+		p.bucket.values[p.getMemoryKey(namespace, key)] = val
+		keys = append(keys, key)
+	}
+	p.bucket.locker.Unlock()
+
+	if len(keys) > 0 {
+		p.bucket.notify(namespace, keys...)
+	}
+
+	return
+}
+
+func (p *MemoryStorage) Get(namespace, key string) (ret interface{}, err error) {
+
+	p.bucket.locker.RLock()
+	defer p.bucket.locker.RUnlock()
+
+	ret = p.bucket.values[p.getMemoryKey(namespace, key)]
+
+	return
+}
+
+func (p *MemoryStorage) GetMulti(namespace string, keys []string) (rets []interface{}, err error) {
+
+	p.bucket.locker.RLock()
+	defer p.bucket.locker.RUnlock()
+
+	for _, key := range keys {
+		rets = append(rets, p.bucket.values[p.getMemoryKey(namespace, key)])
+	}
+
+	return
+}
+
+func (p *MemoryStorage) Scan(namespace, prefix string) (keys []string, err error) {
+
+	memoryPrefix := p.getMemoryKey(namespace, prefix)
+
+	p.bucket.locker.RLock()
+	defer p.bucket.locker.RUnlock()
+
+	for memoryKey := range p.bucket.values {
+		if strings.HasPrefix(memoryKey, memoryPrefix) {
+			keys = append(keys, prefix+strings.TrimPrefix(memoryKey, memoryPrefix))
+		}
+	}
+
+	return
+}
+
+// GetHash return the value set as map[string]interface{} or map[string]string
+func (p *MemoryStorage) GetHash(namespace, key string) (ret map[string]interface{}, err error) {
+
+	p.bucket.locker.RLock()
+	defer p.bucket.locker.RUnlock()
+
+	switch hash := p.bucket.values[p.getMemoryKey(namespace, key)].(type) {
+	case nil:
+	case map[string]interface{}:
+		ret = make(map[string]interface{}, len(hash))
+		for k, v := range hash {
+			ret[k] = v
+		}
+	case map[string]string:
+		ret = make(map[string]interface{}, len(hash))
+		for k, v := range hash {
+			ret[k] = v
+		}
+	default:
+		err = fmt.Errorf("redconf: the value of %s is not a hash", key)
+	}
+
+	return
+}
+
+func (p *MemoryStorage) Delete(namespace, key string) (err error) {
+
+	p.bucket.locker.Lock()
+	delete(p.bucket.values, p.getMemoryKey(namespace, key))
+	p.bucket.locker.Unlock()
+
+	p.bucket.notify(namespace, key)
+
+	return
+}
+
+func getMemoryBucket(name string) *memoryBucket {
+
+	if name == "" {
+		name = DefaultMemoryBucket
+	}
+
+	memoryBucketsLocker.Lock()
+	defer memoryBucketsLocker.Unlock()
+
+	bucket, exist := memoryBuckets[name]
+	if !exist {
+		bucket = &memoryBucket{
+			values:   make(map[string]interface{}),
+			watchers: make(map[string]map[*memoryWatcher]bool),
+		}
+		memoryBuckets[name] = bucket
+	}
+
+	return bucket
+}
+
+func (p *memoryBucket) addWatcher(namespace string, watcher *memoryWatcher) {
+	p.watchersLocker.Lock()
+	defer p.watchersLocker.Unlock()
+
+	watchers, exist := p.watchers[namespace]
+	if !exist {
+		watchers = make(map[*memoryWatcher]bool)
+		p.watchers[namespace] = watchers
+	}
+
+	watchers[watcher] = true
+}
+
+func (p *memoryBucket) removeWatcher(namespace string, watcher *memoryWatcher) {
+	p.watchersLocker.Lock()
+	defer p.watchersLocker.Unlock()
+
+	if watchers, exist := p.watchers[namespace]; exist {
+		delete(watchers, watcher)
+		if len(watchers) == 0 {
+			delete(p.watchers, namespace)
+		}
+	}
+}
+
+func (p *memoryBucket) notify(namespace string, keys ...string) {
+
+	var callbacks []KeyContentChangedCallback
+
+	p.watchersLocker.Lock()
+	for watcher := range p.watchers[namespace] {
+		if watcher.callback != nil {
+			callbacks = append(callbacks, watcher.callback)
+		}
+	}
+	p.watchersLocker.Unlock()
+
+	for _, callback := range callbacks {
+		callback(namespace, keys...)
+	}
+}
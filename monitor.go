@@ -2,17 +2,27 @@ package redconf
 
 import (
 	"errors"
+	"fmt"
+	"reflect"
 	"sync"
 )
 
-type KeyContentChangedCallback func(namespace, key string)
+// KeyContentChangedCallback is called with the keys changed together, they will be
+// applied as one change set, the monitor should call it in the order of changes,
+// and it may block while the queue of RedConf is full
+type KeyContentChangedCallback func(namespace string, keys ...string)
 
 type OnWatchingError func(namespace string, err error)
 
 type NewMonitorFunc func(opts Options) (monitor Monitor, err error)
 
+// Monitor watch the changed keys of namespaces, a namespace is watched once by a monitor,
+// the RedConfs which use the same monitor share the watching of namespace, so the monitor
+// should be comparable, such as the pointer of struct
 type Monitor interface {
 	Watch(namespace string, callback KeyContentChangedCallback, onError OnWatchingError) (err error)
+	Unwatch(namespace string) (err error)
+	Close() (err error)
 }
 
 var (
@@ -65,3 +75,155 @@ func CreateMonitor(driverName string, opts Options) (monitor Monitor, err error)
 
 	return
 }
+
+type monitorWatchingKey struct {
+	monitor   Monitor
+	namespace string
+}
+
+// monitorWatching is the watching of a namespace shared by the RedConfs which use the
+// same monitor, the monitor watches the namespace once and the changes are delivered
+// to all watchers, it is unwatched from the monitor after the last watcher unwatched
+type monitorWatching struct {
+	key monitorWatchingKey
+
+	// closed after the monitor watched, err is the failure of watching
+	ready chan struct{}
+	err   error
+
+	watchers map[*monitorWatcher]bool
+	locker   sync.Mutex
+}
+
+type monitorWatcher struct {
+	watching *monitorWatching
+	callback KeyContentChangedCallback
+	onError  OnWatchingError
+}
+
+var (
+	monitorWatchings = make(map[monitorWatchingKey]*monitorWatching)
+
+	monitorWatchingsLocker sync.Mutex
+)
+
+// watchMonitor add a watcher to the watching of namespace, the monitor watches the
+// namespace while it is the first watcher, the other watchers wait it watched.
+// the monitor is watched without the global lock held, so a slow dial will not
+// block the RedConfs of other monitors
+func watchMonitor(monitor Monitor, namespace string, callback KeyContentChangedCallback, onError OnWatchingError) (watcher *monitorWatcher, err error) {
+
+	// the monitor is the key of map, hash an uncomparable value panics
+	if !reflect.TypeOf(monitor).Comparable() {
+		err = fmt.Errorf("redconf: the monitor of %T is not comparable, use the pointer of it", monitor)
+		return
+	}
+
+	key := monitorWatchingKey{monitor: monitor, namespace: namespace}
+
+	monitorWatchingsLocker.Lock()
+
+	watching, exist := monitorWatchings[key]
+	if !exist {
+		watching = &monitorWatching{
+			key:      key,
+			ready:    make(chan struct{}),
+			watchers: make(map[*monitorWatcher]bool),
+		}
+		monitorWatchings[key] = watching
+	}
+
+	watcher = &monitorWatcher{
+		watching: watching,
+		callback: callback,
+		onError:  onError,
+	}
+
+	// add the watcher before watching, so the changes right after Watch are not missed
+	watching.locker.Lock()
+	watching.watchers[watcher] = true
+	watching.locker.Unlock()
+
+	monitorWatchingsLocker.Unlock()
+
+	if exist {
+		<-watching.ready
+	} else {
+		if watching.err = monitor.Watch(namespace, watching.onKeyContentChanged, watching.onWatchingError); watching.err != nil {
+			watching.remove()
+		}
+		close(watching.ready)
+	}
+
+	if err = watching.err; err != nil {
+		watcher = nil
+	}
+
+	return
+}
+
+// unwatchMonitor remove the watcher, the monitor unwatches the namespace while
+// it is the last watcher
+func unwatchMonitor(watcher *monitorWatcher) (err error) {
+
+	monitorWatchingsLocker.Lock()
+	defer monitorWatchingsLocker.Unlock()
+
+	watching := watcher.watching
+
+	watching.locker.Lock()
+	delete(watching.watchers, watcher)
+	remain := len(watching.watchers)
+	watching.locker.Unlock()
+
+	// the watching already failed is removed while the error occurred
+	if remain > 0 || monitorWatchings[watching.key] != watching {
+		return
+	}
+
+	delete(monitorWatchings, watching.key)
+
+	err = watching.key.monitor.Unwatch(watching.key.namespace)
+
+	return
+}
+
+func (p *monitorWatching) getWatchers() (watchers []*monitorWatcher) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	for watcher := range p.watchers {
+		watchers = append(watchers, watcher)
+	}
+
+	return
+}
+
+func (p *monitorWatching) onKeyContentChanged(namespace string, keys ...string) {
+	for _, watcher := range p.getWatchers() {
+		if watcher.callback != nil {
+			watcher.callback(namespace, keys...)
+		}
+	}
+}
+
+// remove the failed watching, so the watchers could watch again
+func (p *monitorWatching) remove() {
+	monitorWatchingsLocker.Lock()
+	defer monitorWatchingsLocker.Unlock()
+
+	if monitorWatchings[p.key] == p {
+		delete(monitorWatchings, p.key)
+	}
+}
+
+func (p *monitorWatching) onWatchingError(namespace string, err error) {
+
+	p.remove()
+
+	for _, watcher := range p.getWatchers() {
+		if watcher.onError != nil {
+			go watcher.onError(namespace, err)
+		}
+	}
+}
@@ -3,7 +3,9 @@ package redconf
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"reflect"
+	"time"
 )
 
 type Options map[string]interface{}
@@ -25,6 +27,38 @@ func (p Options) Get(name string, v interface{}) (exist bool) {
 	return
 }
 
+// GetDuration accept the option value of time.Duration or string like 1s
+func (p Options) GetDuration(name string, d *time.Duration) (exist bool, err error) {
+
+	var opt interface{}
+	if opt, exist = p[name]; !exist {
+		return
+	}
+
+	switch v := opt.(type) {
+	case time.Duration:
+		*d = v
+	case string:
+		*d, err = time.ParseDuration(v)
+	default:
+		err = fmt.Errorf("redconf: the option of %s should be time.Duration or string", name)
+	}
+
+	return
+}
+
+func mergeOptions(opts ...Options) Options {
+	merged := Options{}
+
+	for _, opt := range opts {
+		for k, v := range opt {
+			merged[k] = v
+		}
+	}
+
+	return merged
+}
+
 func (p Options) ToObject(v interface{}) (err error) {
 	var data []byte
 	if data, err = json.Marshal(p); err != nil {
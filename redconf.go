@@ -1,11 +1,17 @@
 package redconf
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
 )
 
+var (
+	ErrRedConfClosed = errors.New("redconf: closed")
+)
+
 type OnValueChangedSubscriber func(event OnValueChangedEvent)
 
 type OnValueChangedEvent struct {
@@ -14,25 +20,84 @@ type OnValueChangedEvent struct {
 	BeforeValue interface{}
 	AfterValue  interface{}
 	UpdateTime  time.Time
+	// Resync is true while the value changed is found by the resync after monitor reconnected
+	Resync bool
+}
+
+type ErrorStage string
+
+const (
+	ErrorStageStorage    ErrorStage = "storage"
+	ErrorStageConvert    ErrorStage = "convert"
+	ErrorStageMonitor    ErrorStage = "monitor"
+	ErrorStageValidate   ErrorStage = "validate"
+	ErrorStageDispatch   ErrorStage = "dispatch"
+	ErrorStageSubscriber ErrorStage = "subscriber"
+	ErrorStageHook       ErrorStage = "hook"
+)
+
+type OnErrorSubscriber func(event ErrorEvent)
+
+// ErrorEvent is delivered while the value could not get from storage, conv to the field,
+// or failed to validate, and while the monitor is broken, the Value is the raw value from storage
+type ErrorEvent struct {
+	Namespace string
+	Key       string
+	Value     interface{}
+	Stage     ErrorStage
+	Error     error
+	Time      time.Time
 }
 
 type RedConf struct {
 	namespace string
 
+	ctx    context.Context
+	cancel context.CancelFunc
+	closed bool
+
 	watching map[string]*WatchingConfig
 
 	watchingKeyIndex map[string]*Field
 
-	storage Storage
-	monitor Monitor
+	// the raw entries of map fields in keys mode, got from storage last time
+	mapEntries     map[string]map[string]interface{}
+	mapEntriesLock sync.Mutex
+
+	storage        Storage
+	monitor        Monitor
+	monitorWatcher *monitorWatcher
+
+	monitorRetryInterval time.Duration
+
+	copyOnWrite bool
+
+	dispatcher *dispatcher
 
 	confLock sync.Mutex
 
-	subscriber      map[*OnValueChangedSubscriber]bool
-	subscribersLock sync.Mutex
+	subscriber          map[*valueSubscriber]bool
+	errSubscriber       map[*errorSubscriber]bool
+	changeSetSubscriber map[*changeSetSubscriber]bool
+	subscribersLock     sync.Mutex
+}
+
+// New create a RedConf, the options:
+//   - copy_on_write: bool, the updates are applied to a copy of config and swapped, read the config by Snapshot
+//   - queue_size: int, the size of queue for the changes from monitor, default is 1024, 0 is applying in the goroutine of monitor
+//   - overflow: string, while the queue is full, block (default), drop_oldest or drop_newest, the dropped changes will be resynced
+//   - coalesce_window: time.Duration or string like 500ms, the changes within the window are delivered as one change set, and validated one by one
+func New(namespace string, storage Storage, monitor Monitor, opts ...Options) (redConf *RedConf, err error) {
+	return NewWithContext(context.Background(), namespace, storage, monitor, opts...)
 }
 
-func New(namespace string, storage Storage, monitor Monitor) (redConf *RedConf, err error) {
+// NewWithContext create a RedConf which will be closed while the ctx is done
+func NewWithContext(ctx context.Context, namespace string, storage Storage, monitor Monitor, opts ...Options) (redConf *RedConf, err error) {
+
+	if ctx == nil {
+		err = fmt.Errorf("redconf: the context is nil")
+		return
+	}
 
 	if storage == nil {
 		err = fmt.Errorf("redconf: the storage is nil")
@@ -44,15 +109,56 @@ func New(namespace string, storage Storage, monitor Monitor) (redConf *RedConf,
 		return
 	}
 
-	redConf = &RedConf{
+	options := mergeOptions(opts...)
+
+	copyOnWrite := false
+
+	options.Get("copy_on_write", &copyOnWrite)
+
+	queueSize := DefaultDispatchQueueSize
+	overflow := DispatchOverflowBlock
+
+	options.Get("queue_size", &queueSize)
+	options.Get("overflow", &overflow)
+
+	var coalesceWindow time.Duration
+	if _, err = options.GetDuration("coalesce_window", &coalesceWindow); err != nil {
+		return
+	}
+
+	var d *dispatcher
+	if d, err = newDispatcher(queueSize, overflow, coalesceWindow); err != nil {
+		return
+	}
+
+	conf := &RedConf{
 		namespace:        namespace,
+		copyOnWrite:      copyOnWrite,
+		dispatcher:       d,
 		storage:          storage,
 		monitor:          monitor,
 		watching:         make(map[string]*WatchingConfig),
 		watchingKeyIndex: make(map[string]*Field),
-		subscriber:       make(map[*OnValueChangedSubscriber]bool),
+		mapEntries:       make(map[string]map[string]interface{}),
+		subscriber:       make(map[*valueSubscriber]bool),
+		errSubscriber:    make(map[*errorSubscriber]bool),
+
+		changeSetSubscriber: make(map[*changeSetSubscriber]bool),
+
+		monitorRetryInterval: time.Second * 5,
 	}
 
+	conf.ctx, conf.cancel = context.WithCancel(ctx)
+
+	d.process = conf.syncKeys
+	d.resync = conf.resync
+	d.onDropped = conf.onDispatchDropped
+
+	go d.run(conf.ctx)
+	go conf.closeOnDone()
+
+	redConf = conf
+
 	return
 }
 
@@ -74,19 +180,30 @@ func (p *RedConf) Watch(vals ...interface{}) (err error) {
 	return p.WatchWithConfig(confs...)
 }
 
-func (p *RedConf) Subscribe(subscribers ...OnValueChangedSubscriber) {
-	p.subscribersLock.Lock()
-	defer p.subscribersLock.Unlock()
+// Snapshot return the pointer to the current config of the watching value,
+// it should be used for reading the config in copy on write mode
+func (p *RedConf) Snapshot(val interface{}) interface{} {
+	p.confLock.Lock()
+	defer p.confLock.Unlock()
 
-	for _, s := range subscribers {
-		if s == nil {
-			continue
+	for _, conf := range p.watching {
+		if conf.value == val {
+			return conf.Snapshot()
 		}
-		p.subscriber[&s] = true
 	}
+
+	return nil
 }
 
 func (p *RedConf) Keys() []string {
+	p.confLock.Lock()
+	defer p.confLock.Unlock()
+
+	return p.watchingKeys()
+}
+
+func (p *RedConf) watchingKeys() []string {
+
 	var keys []string
 
 	for k := range p.watchingKeyIndex {
@@ -96,13 +213,34 @@ func (p *RedConf) Keys() []string {
 	return keys
 }
 
+// WatchWithConfig watch the configs and sync the values, the configs already watched
+// are ignored, and nothing is changed while it is failed
 func (p *RedConf) WatchWithConfig(configs ...*WatchingConfig) (err error) {
 
+	var result *changeResult
+
+	// publish after the confLock released
+	defer func() {
+		if err == nil && result != nil {
+			p.publishChanges(result)
+		}
+	}()
+
 	p.confLock.Lock()
 	defer p.confLock.Unlock()
 
+	if p.closed {
+		err = ErrRedConfClosed
+		return
+	}
+
 	var watchingKeys []string
+	var newConfigs []*WatchingConfig
+
+	newKeys := make(map[string]*Field)
+	newNames := make(map[string]*WatchingConfig)
 
+	// check all the configs before watching, so the failure changes nothing
 	for _, conf := range configs {
 
 		if wConf, exist := p.watching[conf.name]; exist {
@@ -110,120 +248,363 @@ func (p *RedConf) WatchWithConfig(configs ...*WatchingConfig) (err error) {
 				err = fmt.Errorf("redconf: watch config of %s already exist", wConf.name)
 				return
 			}
+			// the value is already watched
+			continue
+		}
+
+		if wConf, exist := newNames[conf.name]; exist {
+			if wConf.value != conf.value {
+				err = fmt.Errorf("redconf: watch config of %s already exist", wConf.name)
+				return
+			}
+			continue
+		}
+
+		if err = p.checkMapFields(conf); err != nil {
+			return
 		}
-		p.watching[conf.name] = conf
 
 		for _, field := range conf.fields {
-			watchingKeys = append(watchingKeys, field.String())
+			_, exist := p.watchingKeyIndex[field.String()]
+			if !exist {
+				_, exist = newKeys[field.String()]
+			}
 
-			var oldF *Field
-			var exist bool
-			if oldF, exist = p.watchingKeyIndex[field.String()]; !exist {
-				p.watchingKeyIndex[field.String()] = field
-			} else if oldF != field {
+			if exist {
 				err = fmt.Errorf("redconf: the key of %s in namespace %s already have struct to watch", field.String(), p.namespace)
 				return
 			}
+
+			newKeys[field.String()] = field
+			watchingKeys = append(watchingKeys, field.String())
+		}
+
+		newNames[conf.name] = conf
+		newConfigs = append(newConfigs, conf)
+	}
+
+	for _, conf := range newConfigs {
+		p.watching[conf.name] = conf
+		for _, field := range conf.fields {
+			p.watchingKeyIndex[field.String()] = field
 		}
 	}
 
+	defer func() {
+		if err != nil {
+			p.unwatchConfigs(newConfigs...)
+		}
+	}()
+
 	if len(watchingKeys) > 0 {
 
-		if err = p.syncKeys(watchingKeys...); err != nil {
+		keys, mapFields := p.lookupKeys(watchingKeys)
+
+		var kvs map[string]interface{}
+		if kvs, err = p.getValues(keys, mapFields); err != nil {
 			return
 		}
 
-		if err = p.monitor.Watch(p.namespace, p.onKeyContentChanged, p.onMonitorError); err != nil {
+		syncResult := p.newChangeResult(false)
+
+		// all values are prepared before any struct is changed, so a failed watch changes nothing
+		var groups []*changeGroup
+		if groups, err = p.prepareValues(kvs, syncResult.changeSet.UpdateTime); err != nil {
 			return
 		}
+
+		if p.monitorWatcher == nil {
+			if p.monitorWatcher, err = watchMonitor(p.monitor, p.namespace, p.onKeyContentChanged, p.onMonitorError); err != nil {
+				return
+			}
+		}
+
+		for _, group := range groups {
+			p.commitChanges(syncResult, group)
+		}
+		syncResult.sortEvents()
+
+		result = syncResult
+
+		// the values are synced in place, and copy on write for the later updates
+		for _, conf := range newConfigs {
+			conf.valLock.Lock()
+			conf.copyOnWrite = p.copyOnWrite
+			conf.valLock.Unlock()
+		}
+	}
+
+	return
+}
+
+func (p *RedConf) Unwatch(vals ...interface{}) (err error) {
+
+	p.confLock.Lock()
+	defer p.confLock.Unlock()
+
+	for _, val := range vals {
+		for _, conf := range p.watching {
+			if conf.value == val {
+				p.unwatchConfigs(conf)
+			}
+		}
+	}
+
+	if len(p.watching) == 0 && p.monitorWatcher != nil {
+		err = unwatchMonitor(p.monitorWatcher)
+		p.monitorWatcher = nil
+	}
+
+	return
+}
+
+func (p *RedConf) unwatchConfigs(configs ...*WatchingConfig) {
+	for _, conf := range configs {
+		for _, field := range conf.fields {
+			if p.watchingKeyIndex[field.String()] == field {
+				delete(p.watchingKeyIndex, field.String())
+
+				p.mapEntriesLock.Lock()
+				delete(p.mapEntries, field.String())
+				p.mapEntriesLock.Unlock()
+			}
+		}
+
+		if p.watching[conf.name] == conf {
+			delete(p.watching, conf.name)
+		}
+	}
+}
+
+// Close stop watching the namespace and the retry loop of monitor errors,
+// the storage and monitor are not closed, they could be shared by other RedConf
+func (p *RedConf) Close() (err error) {
+
+	p.confLock.Lock()
+	defer p.confLock.Unlock()
+
+	if p.closed {
+		return
+	}
+
+	p.closed = true
+	p.cancel()
+
+	if p.monitorWatcher != nil {
+		err = unwatchMonitor(p.monitorWatcher)
+		p.monitorWatcher = nil
 	}
 
 	return
 }
 
+func (p *RedConf) closeOnDone() {
+	<-p.ctx.Done()
+	p.Close()
+}
+
 func (p *RedConf) Namespace() string {
 	return p.namespace
 }
 
-func (p *RedConf) syncKeys(keys ...string) (err error) {
+// getValues get the values by one round trip while the storage is BatchStorage,
+// and the values of map fields in keys or hash mode are the entries
+func (p *RedConf) getValues(keys []string, mapFields map[string]*mapFieldKeys) (kvs map[string]interface{}, err error) {
 
-	var kvs = make(map[string]interface{})
+	kvs = make(map[string]interface{}, len(keys))
 
-	for _, key := range keys {
-		var val interface{}
-		if val, err = p.storage.Get(p.namespace, key); err != nil {
+	if len(mapFields) > 0 {
+		var valueKeys []string
+
+		for _, key := range keys {
+			mapField, exist := mapFields[key]
+			if !exist {
+				valueKeys = append(valueKeys, key)
+				continue
+			}
+
+			var entries map[string]interface{}
+			if entries, err = p.getMapEntries(mapField); err != nil {
+				return
+			}
+
+			if entries == nil {
+				kvs[key] = nil
+			} else {
+				kvs[key] = entries
+			}
+		}
+
+		keys = valueKeys
+	}
+
+	if len(keys) == 0 {
+		return
+	}
+
+	if batchStorage, ok := p.storage.(BatchStorage); ok {
+		var vals []interface{}
+		if vals, err = batchStorage.GetMulti(p.namespace, keys); err != nil {
 			return
 		}
-		kvs[key] = val
+
+		if len(vals) != len(keys) {
+			err = fmt.Errorf("redconf: the storage returned %d values for %d keys", len(vals), len(keys))
+			return
+		}
+
+		for i, key := range keys {
+			kvs[key] = vals[i]
+		}
+
+		return
 	}
 
-	for k, v := range kvs {
-		if err = p.setFieldValue(k, v); err != nil {
+	for _, key := range keys {
+		var val interface{}
+		if val, err = p.storage.Get(p.namespace, key); err != nil {
 			return
 		}
+		kvs[key] = val
 	}
 
 	return
 }
 
-func (p *RedConf) onKeyContentChanged(namespace, key string) {
-	if namespace != p.namespace {
+func (p *RedConf) onKeyContentChanged(namespace string, keys ...string) {
+	if namespace != p.namespace || len(keys) == 0 {
 		return
 	}
 
-	var err error
-	var value interface{}
-
-	if value, err = p.storage.Get(namespace, key); err != nil {
+	if p.ctx.Err() != nil {
 		return
 	}
 
-	p.setFieldValue(key, value)
+	p.dispatcher.dispatch(p.ctx, keys)
 }
 
-func (p *RedConf) onMonitorError(namespace string, err error) {
-	if namespace != p.namespace {
+func (p *RedConf) onDispatchDropped(keys []string) {
+	for _, key := range keys {
+		p.publishError(ErrorStageDispatch, key, nil, ErrDispatchQueueFull)
+	}
+}
+
+// syncKeys get the values of the change sets, every change set is applied all or nothing,
+// and the changes applied are delivered as one change set by the returned publish
+func (p *RedConf) syncKeys(changeSets [][]string) (publish func()) {
+
+	var keys []string
+	mapFields := make(map[string]*mapFieldKeys)
+	lookedUp := make(map[string]bool)
+
+	p.confLock.Lock()
+	for i, changeSet := range changeSets {
+		var changeSetMapFields map[string]*mapFieldKeys
+		changeSets[i], changeSetMapFields = p.lookupKeys(changeSet)
+
+		for _, key := range changeSets[i] {
+			if !lookedUp[key] {
+				lookedUp[key] = true
+				keys = append(keys, key)
+			}
+		}
+
+		for key, mapField := range changeSetMapFields {
+			if merged, exist := mapFields[key]; exist {
+				merged.merge(mapField)
+			} else {
+				mapFields[key] = mapField
+			}
+		}
+	}
+	p.confLock.Unlock()
+
+	kvs, err := p.getValues(keys, mapFields)
+	if err != nil {
+		publish = func() {
+			for _, key := range keys {
+				p.publishError(ErrorStageStorage, key, nil, err)
+			}
+		}
 		return
 	}
 
-	time.Sleep(time.Second * 5)
+	return p.syncValues(func() *changeResult {
+		return p.applyChanges(kvs, changeSets)
+	})
+}
+
+// resync all watching keys, the changes during the monitor reconnecting will be found
+func (p *RedConf) resync() (publish func()) {
 
-	p.monitor.Watch(p.namespace, p.onKeyContentChanged, p.onMonitorError)
+	p.confLock.Lock()
+	keys, mapFields := p.lookupKeys(p.watchingKeys())
+	p.confLock.Unlock()
+
+	kvs, err := p.getValues(keys, mapFields)
+	if err != nil {
+		publish = func() {
+			p.publishError(ErrorStageStorage, "", nil, err)
+		}
+		return
+	}
+
+	return p.syncValues(func() *changeResult {
+		return p.applyValues(kvs)
+	})
 }
 
-func (p *RedConf) setFieldValue(keyName string, value interface{}) (err error) {
-	var field *Field
-	var exist bool
-	if field, exist = p.watchingKeyIndex[keyName]; !exist {
+// syncValues apply the values with the confLock held, the events are published by
+// the returned publish, so the subscribers are called without any lock held
+func (p *RedConf) syncValues(apply func() *changeResult) (publish func()) {
+
+	p.confLock.Lock()
+	defer p.confLock.Unlock()
+
+	if p.closed {
 		return
 	}
 
-	var newVal interface{}
-	if newVal, err = conv(field.Type(), value); err != nil {
+	result := apply()
+
+	publish = func() {
+		p.publishChanges(result)
+	}
+
+	return
+}
+
+func (p *RedConf) onMonitorError(namespace string, err error) {
+	if namespace != p.namespace {
 		return
 	}
 
-	currentVal := field.Value()
+	p.publishError(ErrorStageMonitor, "", nil, err)
 
-	if fmt.Sprintf("%v", currentVal) == fmt.Sprintf("%v", newVal) {
+	select {
+	case <-p.ctx.Done():
 		return
+	case <-time.After(p.monitorRetryInterval):
 	}
 
-	field.set(newVal)
+	p.confLock.Lock()
 
-	event := OnValueChangedEvent{
-		Namespace:   p.namespace,
-		Key:         keyName,
-		BeforeValue: currentVal,
-		AfterValue:  newVal,
-		UpdateTime:  time.Now(),
+	if p.closed || p.monitorWatcher == nil {
+		p.confLock.Unlock()
+		return
 	}
 
-	for s := range p.subscriber {
-		if s != nil {
-			((*s)(event))
-		}
+	// the failed watching is removed, watch again or join the watching of other RedConf
+	var watcher *monitorWatcher
+	if watcher, err = watchMonitor(p.monitor, p.namespace, p.onKeyContentChanged, p.onMonitorError); err != nil {
+		p.confLock.Unlock()
+		go p.onMonitorError(namespace, err)
+		return
 	}
 
-	return
+	p.monitorWatcher = watcher
+
+	p.confLock.Unlock()
+
+	p.dispatcher.requestResync()
 }
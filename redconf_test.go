@@ -1,7 +1,14 @@
 package redconf
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 type TestBConfig struct {
@@ -84,3 +91,1518 @@ func TestRedConfStructParse(t *testing.T) {
 		}
 	}
 }
+
+var (
+	testBucketSeq int64
+)
+
+// newTestBucket return a fresh memory bucket for the test, and remove it after the test,
+// so the tests could run many times in one process
+func newTestBucket(t *testing.T) string {
+
+	name := fmt.Sprintf("%s#%d", t.Name(), atomic.AddInt64(&testBucketSeq, 1))
+
+	t.Cleanup(func() {
+		memoryBucketsLocker.Lock()
+		delete(memoryBuckets, name)
+		memoryBucketsLocker.Unlock()
+	})
+
+	return name
+}
+
+func newMemoryRedConf(t *testing.T, namespace string) (redConf *RedConf, storage *MemoryStorage) {
+
+	opts := Options{"bucket": newTestBucket(t)}
+
+	var monitor Monitor
+	var memStorage Storage
+	var err error
+
+	if monitor, err = CreateMonitor("memory", opts); err != nil {
+		t.Fatal(err)
+	}
+
+	if memStorage, err = CreateStorage("memory", opts); err != nil {
+		t.Fatal(err)
+	}
+
+	storage = memStorage.(*MemoryStorage)
+
+	// apply the changes in the goroutine of Set, so the tests could check the values after Set
+	if redConf, err = New(namespace, storage, monitor, Options{"queue_size": 0}); err != nil {
+		t.Fatal(err)
+	}
+
+	return
+}
+
+func TestRedConfWatch(t *testing.T) {
+
+	redConf, storage := newMemoryRedConf(t, "NS")
+	defer redConf.Close()
+
+	storage.Set("NS", "TestAConfig:Field1", "value1")
+	storage.Set("NS", "TestAConfig:Config3:Field1", "1,2,3")
+
+	conf := TestAConfig{}
+
+	if err := redConf.Watch(&conf); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if conf.Field1 != "value1" || len(conf.Config3.Field1) != 3 || conf.Config3.Field1[2] != 3 {
+		t.Errorf("sync config failed: %#v", conf)
+		return
+	}
+
+	var events []OnValueChangedEvent
+
+	redConf.Subscribe(func(event OnValueChangedEvent) {
+		events = append(events, event)
+	})
+
+	storage.Set("NS", "TestAConfig:Config3:Field2", "value2")
+	storage.Set("NS", "TestAConfig:Config3:Field2", "value2")
+	storage.Set("NS", "TestAConfig:NotWatched", "value")
+	storage.Set("OTHER", "TestAConfig:Field2", "value")
+
+	if conf.Config3.Field2 != "value2" || conf.Field2 != "" {
+		t.Errorf("update config failed: %#v", conf)
+		return
+	}
+
+	if len(events) != 1 {
+		t.Errorf("excepted 1 event, got: %#v", events)
+		return
+	}
+
+	if events[0].Key != "TestAConfig:Config3:Field2" || events[0].BeforeValue != "" || events[0].AfterValue != "value2" {
+		t.Errorf("unexcepted event: %#v", events[0])
+		return
+	}
+}
+
+func TestRedConfWatchTwice(t *testing.T) {
+
+	redConf, _ := newMemoryRedConf(t, "NS")
+	defer redConf.Close()
+
+	conf := TestAConfig{}
+
+	if err := redConf.Watch(&conf); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// watching the same value again is a no-op
+	if err := redConf.Watch(&conf); err != nil {
+		t.Errorf("watch the same value again should be ignored, got: %s", err)
+		return
+	}
+
+	if err := redConf.Watch(&TestAConfig{}); err == nil {
+		t.Error("watch another value of the same config should be failed")
+		return
+	}
+
+	if redConf.Snapshot(&conf) != &conf {
+		t.Error("the failed watch should not change the watching config")
+		return
+	}
+
+	if err := redConf.Unwatch(&conf); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if keys := redConf.Keys(); len(keys) != 0 {
+		t.Errorf("the keys should be removed after unwatched, got: %v", keys)
+		return
+	}
+}
+
+func TestRedConfShareMonitor(t *testing.T) {
+
+	opts := Options{"bucket": newTestBucket(t)}
+
+	storage, _ := CreateStorage("memory", opts)
+	monitor, _ := CreateMonitor("memory", opts)
+	defer monitor.Close()
+
+	var confs [2]TestAConfig
+	var redConfs [2]*RedConf
+
+	for i := range redConfs {
+		redConf, err := New("NS", storage, monitor, Options{"queue_size": 0})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer redConf.Close()
+
+		if err = redConf.Watch(&confs[i]); err != nil {
+			t.Errorf("the RedConfs should share the monitor within a namespace, got: %s", err)
+			return
+		}
+
+		redConfs[i] = redConf
+	}
+
+	storage.Set("NS", "TestAConfig:Field1", "value1")
+
+	if confs[0].Field1 != "value1" || confs[1].Field1 != "value1" {
+		t.Errorf("the changes should be delivered to all RedConfs, got: %#v", confs)
+		return
+	}
+
+	if err := redConfs[0].Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	storage.Set("NS", "TestAConfig:Field1", "value2")
+
+	if confs[0].Field1 != "value1" || confs[1].Field1 != "value2" {
+		t.Errorf("the other RedConf should still watch after one closed, got: %#v", confs)
+		return
+	}
+
+	if err := redConfs[1].Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// the namespace is unwatched from the monitor after the last RedConf closed
+	if err := monitor.Watch("NS", nil, nil); err != nil {
+		t.Errorf("the namespace should be unwatched from the monitor, got: %s", err)
+	}
+}
+
+type TestWatchFailedConfig struct {
+	Good int
+	Bad  int `redconf:"bad,required"`
+}
+
+func TestRedConfWatchFailedUnchanged(t *testing.T) {
+
+	redConf, storage := newMemoryRedConf(t, "NS")
+	defer redConf.Close()
+
+	storage.Set("NS", "TestWatchFailedConfig:Good", "42")
+	storage.Set("NS", "TestAConfig:Field1", "value1")
+
+	conf := TestWatchFailedConfig{}
+	aConf := TestAConfig{}
+
+	if err := redConf.Watch(&aConf, &conf); err == nil {
+		t.Error("watch with the missing required key should be failed")
+		return
+	}
+
+	if conf.Good != 0 || aConf.Field1 != "" {
+		t.Errorf("the structs should not be changed by the failed watch, got: %#v, %#v", conf, aConf)
+		return
+	}
+
+	if keys := redConf.Keys(); len(keys) != 0 {
+		t.Errorf("the keys should not be watched by the failed watch, got: %v", keys)
+		return
+	}
+
+	storage.Set("NS", "TestWatchFailedConfig:bad", "1")
+
+	if err := redConf.Watch(&aConf, &conf); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if conf.Good != 42 || conf.Bad != 1 || aConf.Field1 != "value1" {
+		t.Errorf("watch again failed, got: %#v, %#v", conf, aConf)
+	}
+}
+
+// uncomparableMonitor is a struct value with a map, it could not be the key of map
+type uncomparableMonitor struct {
+	Monitor
+	tags map[string]string
+}
+
+func TestRedConfUncomparableMonitor(t *testing.T) {
+
+	opts := Options{"bucket": newTestBucket(t)}
+
+	storage, _ := CreateStorage("memory", opts)
+	monitor, _ := CreateMonitor("memory", opts)
+
+	redConf, err := New("NS", storage, uncomparableMonitor{Monitor: monitor}, Options{"queue_size": 0})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer redConf.Close()
+
+	conf := TestAConfig{}
+
+	if err = redConf.Watch(&conf); err == nil {
+		t.Error("watch with the uncomparable monitor should be failed")
+		return
+	}
+
+	if keys := redConf.Keys(); len(keys) != 0 {
+		t.Errorf("the keys should not be watched by the failed watch, got: %v", keys)
+	}
+}
+
+type slowMonitor struct {
+	Monitor
+	release chan bool
+}
+
+func (p *slowMonitor) Watch(namespace string, callback KeyContentChangedCallback, onError OnWatchingError) (err error) {
+	<-p.release
+	return p.Monitor.Watch(namespace, callback, onError)
+}
+
+func TestRedConfSlowMonitor(t *testing.T) {
+
+	opts := Options{"bucket": newTestBucket(t)}
+
+	storage, _ := CreateStorage("memory", opts)
+	monitor, _ := CreateMonitor("memory", opts)
+
+	slow := &slowMonitor{Monitor: monitor, release: make(chan bool)}
+
+	slowRedConf, err := New("NS", storage, slow, Options{"queue_size": 0})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer slowRedConf.Close()
+
+	slowErr := make(chan error, 1)
+	go func() {
+		slowErr <- slowRedConf.Watch(&TestAConfig{})
+	}()
+
+	// the slow watching of one monitor should not block the others
+	redConf, _ := newMemoryRedConf(t, "NS")
+	defer redConf.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- redConf.Watch(&TestAConfig{})
+	}()
+
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Error("the watch is blocked by the slow monitor")
+	}
+
+	close(slow.release)
+
+	if err = <-slowErr; err != nil {
+		t.Error(err)
+	}
+}
+
+type TestValueEqualConfig struct {
+	Hosts []string  `redconf:"hosts,encoding=json"`
+	Pair  [2]string `redconf:"pair"`
+	At    time.Time `redconf:"at"`
+}
+
+func TestRedConfValueEqual(t *testing.T) {
+
+	redConf, storage := newMemoryRedConf(t, "NS")
+	defer redConf.Close()
+
+	storage.Set("NS", "TestValueEqualConfig:hosts", `["a b"]`)
+	storage.Set("NS", "TestValueEqualConfig:pair", "x y,z")
+	storage.Set("NS", "TestValueEqualConfig:at", "2020-01-01T08:00:00+08:00")
+
+	conf := TestValueEqualConfig{}
+
+	if err := redConf.Watch(&conf); err != nil {
+		t.Error(err)
+		return
+	}
+
+	var events []OnValueChangedEvent
+	redConf.Subscribe(func(event OnValueChangedEvent) {
+		events = append(events, event)
+	})
+
+	// the values print the same as before, but they are changed
+	storage.Set("NS", "TestValueEqualConfig:hosts", `["a","b"]`)
+	storage.Set("NS", "TestValueEqualConfig:pair", "x,y z")
+
+	if !reflect.DeepEqual(conf.Hosts, []string{"a", "b"}) || conf.Pair != [2]string{"x", "y z"} {
+		t.Errorf("the changed values which print the same are skipped, got: %#v", conf)
+		return
+	}
+
+	// the same instant in another location is not changed
+	storage.Set("NS", "TestValueEqualConfig:at", "2020-01-01T00:00:00Z")
+
+	if len(events) != 2 {
+		t.Errorf("excepted 2 events, got: %#v", events)
+	}
+}
+
+func TestRedConfUnwatchAndClose(t *testing.T) {
+
+	redConf, storage := newMemoryRedConf(t, "NS")
+
+	conf := TestAConfig{}
+
+	if err := redConf.Watch(&conf); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := redConf.Unwatch(&conf); err != nil {
+		t.Error(err)
+		return
+	}
+
+	storage.Set("NS", "TestAConfig:Field1", "value1")
+
+	if conf.Field1 != "" {
+		t.Error("the config still updated after unwatch")
+		return
+	}
+
+	if len(redConf.Keys()) != 0 {
+		t.Errorf("the keys still exist after unwatch: %v", redConf.Keys())
+		return
+	}
+
+	if err := redConf.Watch(&conf); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if conf.Field1 != "value1" {
+		t.Error("watch again failed")
+		return
+	}
+
+	if err := redConf.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	storage.Set("NS", "TestAConfig:Field1", "value2")
+
+	if conf.Field1 != "value1" {
+		t.Error("the config still updated after close")
+		return
+	}
+
+	if err := redConf.Watch(&TestBConfig{}); err != ErrRedConfClosed {
+		t.Errorf("excepted closed error, got: %v", err)
+		return
+	}
+}
+
+func TestRedConfResyncAfterReconnect(t *testing.T) {
+
+	redConf, storage := newMemoryRedConf(t, "NS")
+	defer redConf.Close()
+
+	redConf.monitorRetryInterval = time.Millisecond
+
+	conf := TestAConfig{}
+
+	if err := redConf.Watch(&conf); err != nil {
+		t.Error(err)
+		return
+	}
+
+	var events []OnValueChangedEvent
+
+	redConf.Subscribe(func(event OnValueChangedEvent) {
+		events = append(events, event)
+	})
+
+	// the changes during the monitor reconnecting are missed
+	redConf.monitor.Unwatch("NS")
+	redConf.monitorWatcher.watching.remove()
+
+	storage.Set("NS", "TestAConfig:Field1", "value1")
+
+	if conf.Field1 != "" {
+		t.Error("the config updated without monitor")
+		return
+	}
+
+	redConf.onMonitorError("NS", errors.New("connection lost"))
+
+	if conf.Field1 != "value1" {
+		t.Error("resync failed")
+		return
+	}
+
+	if len(events) != 1 || !events[0].Resync || events[0].Key != "TestAConfig:Field1" {
+		t.Errorf("unexcepted events: %#v", events)
+		return
+	}
+
+	storage.Set("NS", "TestAConfig:Field2", "value2")
+
+	if conf.Field2 != "value2" {
+		t.Error("the monitor is not watching after reconnected")
+		return
+	}
+}
+
+type countingStorage struct {
+	*MemoryStorage
+	gets      int
+	getMultis int
+}
+
+func (p *countingStorage) Get(namespace, key string) (ret interface{}, err error) {
+	p.gets++
+	return p.MemoryStorage.Get(namespace, key)
+}
+
+func (p *countingStorage) GetMulti(namespace string, keys []string) (rets []interface{}, err error) {
+	p.getMultis++
+	return p.MemoryStorage.GetMulti(namespace, keys)
+}
+
+func TestRedConfSyncByBatchStorage(t *testing.T) {
+
+	opts := Options{"bucket": newTestBucket(t)}
+
+	memStorage, _ := CreateStorage("memory", opts)
+
+	storage := &countingStorage{MemoryStorage: memStorage.(*MemoryStorage)}
+
+	storage.Set("NS", "TestAConfig:Field1", "value1")
+	storage.Set("NS", "TestAConfig:Config3:Field2", "value2")
+
+	monitor, _ := CreateMonitor("memory", opts)
+
+	redConf, err := New("NS", storage, monitor)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer redConf.Close()
+
+	conf := TestAConfig{}
+
+	if err = redConf.Watch(&conf); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if conf.Field1 != "value1" || conf.Config3.Field2 != "value2" {
+		t.Errorf("sync config failed: %#v", conf)
+		return
+	}
+
+	if storage.gets != 0 || storage.getMultis != 1 {
+		t.Errorf("excepted 1 GetMulti and 0 Get, got %d GetMulti and %d Get", storage.getMultis, storage.gets)
+		return
+	}
+}
+
+type TestTagServerConfig struct {
+	Host    string `redconf:"host,default=127.0.0.1"`
+	Port    int    `redconf:"port,default=8080"`
+	Name    string `redconf:"name,required"`
+	Ignored string `redconf:"-"`
+}
+
+type TestTagConfig struct {
+	Server TestTagServerConfig `redconf:"server"`
+	Tags   []string            `redconf:"tags,default=a,b"`
+}
+
+func TestRedConfFieldTag(t *testing.T) {
+
+	redConf, storage := newMemoryRedConf(t, "NS")
+	defer redConf.Close()
+
+	conf := TestTagConfig{}
+
+	if err := redConf.Watch(&conf); err == nil {
+		t.Error("watch should be failed while the required key is missing")
+		return
+	}
+
+	if len(redConf.Keys()) != 0 {
+		t.Errorf("the keys should be removed while watch failed: %v", redConf.Keys())
+		return
+	}
+
+	storage.Set("NS", "TestTagConfig:server:name", "app")
+	storage.Set("NS", "TestTagConfig:server:port", "18080")
+
+	if err := redConf.Watch(&conf); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(redConf.Keys()) != 4 {
+		t.Errorf("unexcepted keys: %v", redConf.Keys())
+		return
+	}
+
+	if conf.Server.Host != "127.0.0.1" || conf.Server.Port != 18080 || conf.Server.Name != "app" ||
+		len(conf.Tags) != 2 || conf.Tags[1] != "b" {
+		t.Errorf("sync config failed: %#v", conf)
+		return
+	}
+
+	storage.Delete("NS", "TestTagConfig:server:port")
+
+	if conf.Server.Port != 8080 {
+		t.Errorf("the default value should be used while the key deleted, got: %d", conf.Server.Port)
+		return
+	}
+
+	storage.Delete("NS", "TestTagConfig:server:name")
+
+	if conf.Server.Name != "app" {
+		t.Errorf("the required value should be retained while the key deleted, got: %s", conf.Server.Name)
+		return
+	}
+}
+
+type TestValidateConfig struct {
+	Port    int    `redconf:",min=1,max=65535"`
+	Level   string `redconf:",oneof=debug info warn"`
+	Name    string `redconf:",regexp=^[a-z]{1,8}$"`
+	MinPort int
+	MaxPort int
+}
+
+func (p *TestValidateConfig) Validate() error {
+	if p.MinPort > p.MaxPort {
+		return errors.New("the MinPort is greater than MaxPort")
+	}
+	return nil
+}
+
+func TestRedConfValidate(t *testing.T) {
+
+	redConf, storage := newMemoryRedConf(t, "NS")
+	defer redConf.Close()
+
+	storage.Set("NS", "TestValidateConfig:Port", "8080")
+	storage.Set("NS", "TestValidateConfig:Level", "info")
+	storage.Set("NS", "TestValidateConfig:Name", "app")
+	storage.Set("NS", "TestValidateConfig:MinPort", "8000")
+	storage.Set("NS", "TestValidateConfig:MaxPort", "9000")
+
+	conf := TestValidateConfig{}
+
+	if err := redConf.Watch(&conf); err != nil {
+		t.Error(err)
+		return
+	}
+
+	var events []OnValueChangedEvent
+	var errEvents []ErrorEvent
+
+	redConf.Subscribe(func(event OnValueChangedEvent) {
+		events = append(events, event)
+	})
+
+	redConf.SubscribeErrors(func(event ErrorEvent) {
+		errEvents = append(errEvents, event)
+	})
+
+	invalidValues := map[string]string{
+		"TestValidateConfig:Port":    "70000",
+		"TestValidateConfig:Level":   "trace",
+		"TestValidateConfig:Name":    "App,Name",
+		"TestValidateConfig:MinPort": "9001",
+	}
+
+	for key, value := range invalidValues {
+		events, errEvents = nil, nil
+
+		storage.Set("NS", key, value)
+
+		// the rejected value is only reported to the error subscribers
+		if len(events) != 0 {
+			t.Errorf("the rejected value of %s should not be published, got: %#v", key, events)
+			return
+		}
+
+		if len(errEvents) != 1 || errEvents[0].Stage != ErrorStageValidate || errEvents[0].Value != value || errEvents[0].Key != key {
+			t.Errorf("excepted validate error of %s, got: %#v", key, errEvents)
+			return
+		}
+	}
+
+	if conf.Port != 8080 || conf.Level != "info" || conf.Name != "app" || conf.MinPort != 8000 {
+		t.Errorf("the previous values should be retained, got: %#v", conf)
+		return
+	}
+
+	events, errEvents = nil, nil
+
+	storage.Set("NS", "TestValidateConfig:MinPort", "8500")
+
+	if conf.MinPort != 8500 || len(events) != 1 || len(errEvents) != 0 {
+		t.Errorf("update valid value failed, got: %#v", events)
+		return
+	}
+}
+
+func TestRedConfSubscribeErrors(t *testing.T) {
+
+	redConf, storage := newMemoryRedConf(t, "NS")
+	defer redConf.Close()
+
+	conf := TestValidateConfig{}
+
+	storage.Set("NS", "TestValidateConfig:Port", "8080")
+
+	if err := redConf.Watch(&conf); err != nil {
+		t.Error(err)
+		return
+	}
+
+	var errEvents []ErrorEvent
+
+	redConf.SubscribeErrors(func(event ErrorEvent) {
+		errEvents = append(errEvents, event)
+	})
+
+	storage.Set("NS", "TestValidateConfig:Port", "abc")
+
+	if len(errEvents) != 1 || errEvents[0].Stage != ErrorStageConvert ||
+		errEvents[0].Key != "TestValidateConfig:Port" || errEvents[0].Value != "abc" || errEvents[0].Error == nil {
+		t.Errorf("unexcepted error events: %#v", errEvents)
+		return
+	}
+
+	errEvents = nil
+
+	storage.Set("NS", "TestValidateConfig:Port", "0")
+
+	if len(errEvents) != 1 || errEvents[0].Stage != ErrorStageValidate {
+		t.Errorf("unexcepted error events: %#v", errEvents)
+		return
+	}
+
+	if conf.Port != 8080 {
+		t.Errorf("the previous value should be retained, got: %d", conf.Port)
+		return
+	}
+}
+
+type TestSnapshotConfig struct {
+	Server *TestBConfig
+	Port   int
+}
+
+func TestRedConfCopyOnWriteSnapshot(t *testing.T) {
+
+	opts := Options{"bucket": newTestBucket(t)}
+
+	storage, _ := CreateStorage("memory", opts)
+	monitor, _ := CreateMonitor("memory", opts)
+
+	redConf, err := New("NS", storage, monitor, Options{"copy_on_write": true, "queue_size": 0})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer redConf.Close()
+
+	storage.Set("NS", "TestSnapshotConfig:Server:Field2", "value0")
+
+	conf := TestSnapshotConfig{}
+
+	if err = redConf.Watch(&conf); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if redConf.Snapshot(&conf) != &conf {
+		t.Error("the snapshot should be the watching value before updated")
+		return
+	}
+
+	server := conf.Server
+
+	done := make(chan bool)
+
+	go func() {
+		for i := 1; i <= 100; i++ {
+			storage.Set("NS", "TestSnapshotConfig:Port", strconv.Itoa(i))
+			storage.Set("NS", "TestSnapshotConfig:Server:Field2", "value"+strconv.Itoa(i))
+		}
+		close(done)
+	}()
+
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+
+		snapshot := redConf.Snapshot(&conf).(*TestSnapshotConfig)
+		if snapshot.Port < 0 || snapshot.Server.Field2 == "" {
+			t.Errorf("unexcepted snapshot: %#v", snapshot)
+			return
+		}
+	}
+
+	snapshot := redConf.Snapshot(&conf).(*TestSnapshotConfig)
+
+	if snapshot.Port != 100 || snapshot.Server.Field2 != "value100" {
+		t.Errorf("unexcepted snapshot: %#v", snapshot.Server)
+		return
+	}
+
+	if conf.Port != 0 || conf.Server != server || server.Field2 != "value0" {
+		t.Error("the watching value should not be changed in copy on write mode")
+		return
+	}
+}
+
+func TestRedConfChangeSet(t *testing.T) {
+
+	redConf, storage := newMemoryRedConf(t, "NS")
+	defer redConf.Close()
+
+	storage.SetMulti("NS", map[string]interface{}{
+		"TestValidateConfig:MinPort": "8000",
+		"TestValidateConfig:MaxPort": "9000",
+	})
+
+	conf := TestValidateConfig{}
+
+	if err := redConf.Watch(&conf); err != nil {
+		t.Error(err)
+		return
+	}
+
+	var changeSets []ChangeSet
+	var events []OnValueChangedEvent
+
+	redConf.SubscribeChangeSet(func(changeSet ChangeSet) {
+		changeSets = append(changeSets, changeSet)
+	})
+
+	redConf.Subscribe(func(event OnValueChangedEvent) {
+		events = append(events, event)
+	})
+
+	// the MinPort is greater than the old MaxPort, it is valid only while changed together
+	storage.SetMulti("NS", map[string]interface{}{
+		"TestValidateConfig:MinPort": "9500",
+		"TestValidateConfig:MaxPort": "10000",
+	})
+
+	if conf.MinPort != 9500 || conf.MaxPort != 10000 {
+		t.Errorf("apply change set failed: %#v", conf)
+		return
+	}
+
+	if len(changeSets) != 1 || !reflect.DeepEqual(changeSets[0].Keys(), []string{"TestValidateConfig:MaxPort", "TestValidateConfig:MinPort"}) {
+		t.Errorf("unexcepted change sets: %#v", changeSets)
+		return
+	}
+
+	if len(events) != 2 {
+		t.Errorf("excepted 2 events, got: %#v", events)
+		return
+	}
+
+	var errEvents []ErrorEvent
+
+	redConf.SubscribeErrors(func(event ErrorEvent) {
+		errEvents = append(errEvents, event)
+	})
+
+	changeSets, events = nil, nil
+
+	// all or nothing, the valid Port is rejected with the invalid Level
+	storage.SetMulti("NS", map[string]interface{}{
+		"TestValidateConfig:Port":  "8080",
+		"TestValidateConfig:Level": "trace",
+	})
+
+	if conf.Port != 0 || conf.Level != "" {
+		t.Errorf("the previous values should be retained, got: %#v", conf)
+		return
+	}
+
+	if len(changeSets) != 0 || len(events) != 0 {
+		t.Errorf("the rejected change set should not be published, got: %#v", events)
+		return
+	}
+
+	if len(errEvents) != 2 || errEvents[0].Stage != ErrorStageValidate || errEvents[1].Stage != ErrorStageValidate {
+		t.Errorf("excepted the validate errors of the change set, got: %#v", errEvents)
+		return
+	}
+}
+
+type TestResyncConfig struct {
+	A int
+	B string
+}
+
+func TestRedConfResyncBadKey(t *testing.T) {
+
+	redConf, storage := newMemoryRedConf(t, "NS")
+	defer redConf.Close()
+
+	conf := TestResyncConfig{}
+
+	if err := redConf.Watch(&conf); err != nil {
+		t.Error(err)
+		return
+	}
+
+	var errEvents []ErrorEvent
+	redConf.SubscribeErrors(func(event ErrorEvent) {
+		errEvents = append(errEvents, event)
+	})
+
+	storage.Set("NS", "TestResyncConfig:A", "abc")
+
+	// the drifted value without notification
+	storage.bucket.locker.Lock()
+	storage.bucket.values["NS:TestResyncConfig:B"] = "drifted"
+	storage.bucket.locker.Unlock()
+
+	errEvents = nil
+
+	redConf.dispatcher.requestResync()
+
+	if conf.A != 0 || conf.B != "drifted" {
+		t.Errorf("the bad key should not block the resync of other keys, got: %#v", conf)
+		return
+	}
+
+	if len(errEvents) != 1 || errEvents[0].Key != "TestResyncConfig:A" || errEvents[0].Stage != ErrorStageConvert {
+		t.Errorf("the bad key should be reported on its own, got: %#v", errEvents)
+		return
+	}
+}
+
+func waitSnapshot(redConf *RedConf, val interface{}, ok func(snapshot interface{}) bool) bool {
+	for i := 0; i < 100; i++ {
+		if ok(redConf.Snapshot(val)) {
+			return true
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	return false
+}
+
+func TestRedConfDispatchInOrder(t *testing.T) {
+
+	opts := Options{"bucket": newTestBucket(t)}
+
+	storage, _ := CreateStorage("memory", opts)
+	monitor, _ := CreateMonitor("memory", opts)
+
+	redConf, err := New("NS", storage, monitor, Options{"copy_on_write": true})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer redConf.Close()
+
+	conf := TestSnapshotConfig{}
+
+	if err = redConf.Watch(&conf); err != nil {
+		t.Error(err)
+		return
+	}
+
+	eventsC := make(chan OnValueChangedEvent, 100)
+
+	redConf.Subscribe(func(event OnValueChangedEvent) {
+		eventsC <- event
+	})
+
+	for i := 1; i <= 100; i++ {
+		storage.Set("NS", "TestSnapshotConfig:Port", strconv.Itoa(i))
+	}
+
+	if !waitSnapshot(redConf, &conf, func(s interface{}) bool { return s.(*TestSnapshotConfig).Port == 100 }) {
+		t.Errorf("the last value should win, got: %#v", redConf.Snapshot(&conf))
+		return
+	}
+
+	// the events are published after applied, wait for the event of last value
+	lastPort := 0
+	for lastPort < 100 {
+		select {
+		case event := <-eventsC:
+			port := event.AfterValue.(int)
+			if port <= lastPort {
+				t.Errorf("the change of %d is applied after %d", port, lastPort)
+				return
+			}
+			lastPort = port
+		case <-time.After(time.Second):
+			t.Errorf("the event of last value is not published, got %d", lastPort)
+			return
+		}
+	}
+}
+
+// blockingStorage block the Get while blocking, so the changes are queued
+type blockingStorage struct {
+	Storage
+	blocking int32
+	started  chan bool
+	release  chan bool
+}
+
+func (p *blockingStorage) Get(namespace, key string) (ret interface{}, err error) {
+	if atomic.LoadInt32(&p.blocking) == 1 {
+		select {
+		case p.started <- true:
+		default:
+		}
+		<-p.release
+	}
+
+	return p.Storage.Get(namespace, key)
+}
+
+func TestRedConfDispatchOverflow(t *testing.T) {
+
+	opts := Options{"bucket": newTestBucket(t)}
+
+	memStorage, _ := CreateStorage("memory", opts)
+	monitor, _ := CreateMonitor("memory", opts)
+
+	started := make(chan bool, 10)
+	release := make(chan bool)
+
+	storage := &blockingStorage{Storage: memStorage, started: started, release: release}
+
+	redConf, err := New("NS", storage, monitor, Options{"copy_on_write": true, "queue_size": 1, "overflow": DispatchOverflowDropNewest})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer redConf.Close()
+
+	conf := TestSnapshotConfig{}
+
+	if err = redConf.Watch(&conf); err != nil {
+		t.Error(err)
+		return
+	}
+
+	atomic.StoreInt32(&storage.blocking, 1)
+
+	var errEvents []ErrorEvent
+
+	redConf.SubscribeErrors(func(event ErrorEvent) {
+		errEvents = append(errEvents, event)
+	})
+
+	storage.Set("NS", "TestSnapshotConfig:Port", "1")
+
+	<-started
+
+	storage.Set("NS", "TestSnapshotConfig:Port", "2")
+	storage.Set("NS", "TestSnapshotConfig:Port", "3")
+
+	if len(errEvents) != 1 || errEvents[0].Stage != ErrorStageDispatch || errEvents[0].Key != "TestSnapshotConfig:Port" {
+		t.Errorf("excepted the dropped error, got: %#v", errEvents)
+		return
+	}
+
+	close(release)
+
+	// the dropped change is recovered by resync
+	if !waitSnapshot(redConf, &conf, func(s interface{}) bool { return s.(*TestSnapshotConfig).Port == 3 }) {
+		t.Errorf("the dropped change should be resynced, got: %#v", redConf.Snapshot(&conf))
+		return
+	}
+}
+
+func TestRedConfSubscriberWriteStorage(t *testing.T) {
+
+	for _, queueSize := range []int{0, 1} {
+
+		opts := Options{"bucket": newTestBucket(t)}
+
+		storage, _ := CreateStorage("memory", opts)
+		monitor, _ := CreateMonitor("memory", opts)
+
+		redConf, err := New("NS", storage, monitor, Options{"copy_on_write": true, "queue_size": queueSize})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer redConf.Close()
+
+		conf := TestAConfig{}
+
+		if err = redConf.Watch(&conf); err != nil {
+			t.Error(err)
+			return
+		}
+
+		// the subscriber writes the storage, and the changes of it are applied too
+		redConf.SubscribeKey("TestAConfig:Field1", func(event OnValueChangedEvent) {
+			for i := 0; i < 3; i++ {
+				storage.Set("NS", "TestAConfig:Field2", fmt.Sprintf("%v-%d", event.AfterValue, i))
+			}
+		})
+
+		done := make(chan bool)
+		go func() {
+			storage.Set("NS", "TestAConfig:Field1", "value1")
+			close(done)
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(time.Second * 5):
+			t.Errorf("the write of subscriber is blocked with queue size %d", queueSize)
+			return
+		}
+
+		if !waitSnapshot(redConf, &conf, func(s interface{}) bool { return s.(*TestAConfig).Field2 == "value1-2" }) {
+			t.Errorf("the write of subscriber is not applied with queue size %d, got: %#v", queueSize, redConf.Snapshot(&conf))
+			return
+		}
+	}
+}
+
+func TestRedConfCoalesce(t *testing.T) {
+
+	opts := Options{"bucket": newTestBucket(t)}
+
+	storage, _ := CreateStorage("memory", opts)
+	monitor, _ := CreateMonitor("memory", opts)
+
+	redConf, err := New("NS", storage, monitor, Options{"copy_on_write": true, "coalesce_window": "100ms"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer redConf.Close()
+
+	conf := TestValidateConfig{}
+
+	if err = redConf.Watch(&conf); err != nil {
+		t.Error(err)
+		return
+	}
+
+	changeSetsC := make(chan ChangeSet, 10)
+
+	redConf.SubscribeChangeSet(func(changeSet ChangeSet) {
+		changeSetsC <- changeSet
+	})
+
+	storage.Set("NS", "TestValidateConfig:Port", "8080")
+	storage.Set("NS", "TestValidateConfig:MaxPort", "9000")
+	storage.Set("NS", "TestValidateConfig:MinPort", "8000")
+	storage.Set("NS", "TestValidateConfig:Port", "8081")
+
+	select {
+	case changeSet := <-changeSetsC:
+		exceptedKeys := []string{"TestValidateConfig:MaxPort", "TestValidateConfig:MinPort", "TestValidateConfig:Port"}
+		if !reflect.DeepEqual(changeSet.Keys(), exceptedKeys) || changeSet.Events[2].AfterValue != 8081 {
+			t.Errorf("unexcepted change set: %#v", changeSet)
+			return
+		}
+	case <-time.After(time.Second):
+		t.Error("wait change set timeout")
+		return
+	}
+
+	select {
+	case changeSet := <-changeSetsC:
+		t.Errorf("the changes should be coalesced, got: %#v", changeSet)
+	case <-time.After(time.Millisecond * 200):
+	}
+
+	// the coalesced changes are validated one by one, the invalid Level does not reject the Name
+	storage.Set("NS", "TestValidateConfig:Level", "trace")
+	storage.Set("NS", "TestValidateConfig:Name", "app")
+
+	select {
+	case changeSet := <-changeSetsC:
+		if !reflect.DeepEqual(changeSet.Keys(), []string{"TestValidateConfig:Name"}) {
+			t.Errorf("unexcepted change set: %#v", changeSet)
+			return
+		}
+	case <-time.After(time.Second):
+		t.Error("wait change set timeout")
+		return
+	}
+
+	snapshot := redConf.Snapshot(&conf).(*TestValidateConfig)
+	if snapshot.Name != "app" || snapshot.Level != "" {
+		t.Errorf("the valid change should be applied, got: %#v", snapshot)
+		return
+	}
+
+	if _, err = New("NS", storage, monitor, Options{"queue_size": 0, "coalesce_window": time.Second}); err == nil {
+		t.Error("the coalesce window without queue should be failed")
+		return
+	}
+}
+
+func TestRedConfScopedSubscribe(t *testing.T) {
+
+	redConf, storage := newMemoryRedConf(t, "NS")
+	defer redConf.Close()
+
+	confA := TestAConfig{}
+	confS := TestSnapshotConfig{}
+
+	if err := redConf.Watch(&confA, &confS); err != nil {
+		t.Error(err)
+		return
+	}
+
+	received := map[string][]string{}
+
+	subscriber := func(name string) OnValueChangedSubscriber {
+		return func(event OnValueChangedEvent) {
+			received[name] = append(received[name], event.Key)
+		}
+	}
+
+	redConf.SubscribeKey("TestAConfig:Field1", subscriber("key"))
+	redConf.SubscribePrefix("TestAConfig:Config3:*", subscriber("prefix"))
+
+	if _, err := redConf.SubscribeStruct(&confA.Config3, subscriber("struct")); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, err := redConf.SubscribeStruct(confS.Server, subscriber("pointer")); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, err := redConf.SubscribeStruct(&confS, subscriber("top")); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, err := redConf.SubscribeStruct(&TestBConfig{}, subscriber("unwatched")); err == nil {
+		t.Error("subscribe the unwatched struct should be failed")
+		return
+	}
+
+	storage.Set("NS", "TestAConfig:Field1", "value1")
+	storage.Set("NS", "TestAConfig:Field2", "value2")
+	storage.Set("NS", "TestAConfig:Config3:Field2", "value3")
+	storage.Set("NS", "TestSnapshotConfig:Server:Field2", "value4")
+	storage.Set("NS", "TestSnapshotConfig:Port", "8080")
+
+	excepted := map[string][]string{
+		"key":     {"TestAConfig:Field1"},
+		"prefix":  {"TestAConfig:Config3:Field2"},
+		"struct":  {"TestAConfig:Config3:Field2"},
+		"pointer": {"TestSnapshotConfig:Server:Field2"},
+		"top":     {"TestSnapshotConfig:Server:Field2", "TestSnapshotConfig:Port"},
+	}
+
+	if !reflect.DeepEqual(received, excepted) {
+		t.Errorf("unexcepted received keys: %v", received)
+		return
+	}
+}
+
+func TestRedConfUnsubscribe(t *testing.T) {
+
+	redConf, storage := newMemoryRedConf(t, "NS")
+	defer redConf.Close()
+
+	conf := TestValidateConfig{}
+
+	if err := redConf.Watch(&conf); err != nil {
+		t.Error(err)
+		return
+	}
+
+	count1, count2, errCount := 0, 0, 0
+
+	sub := redConf.Subscribe(
+		func(event OnValueChangedEvent) { count1++ },
+		func(event OnValueChangedEvent) { count2++ },
+	)
+
+	errSub := redConf.SubscribeErrors(func(event ErrorEvent) { errCount++ })
+
+	storage.Set("NS", "TestValidateConfig:Port", "8080")
+	storage.Set("NS", "TestValidateConfig:Port", "abc")
+
+	if count1 != 1 || count2 != 1 || errCount != 1 {
+		t.Errorf("all the subscribers should be called, got: %d, %d, %d", count1, count2, errCount)
+		return
+	}
+
+	sub.Unsubscribe()
+	sub.Unsubscribe()
+	errSub.Unsubscribe()
+
+	storage.Set("NS", "TestValidateConfig:Port", "8081")
+	storage.Set("NS", "TestValidateConfig:Port", "abc")
+
+	if count1 != 1 || count2 != 1 || errCount != 1 {
+		t.Errorf("the subscribers should not be called after unsubscribed, got: %d, %d, %d", count1, count2, errCount)
+		return
+	}
+
+	if len(redConf.subscriber) != 0 || len(redConf.errSubscriber) != 0 {
+		t.Error("the subscribers should be removed")
+		return
+	}
+}
+
+func TestRedConfEvents(t *testing.T) {
+
+	redConf, storage := newMemoryRedConf(t, "NS")
+	defer redConf.Close()
+
+	conf := TestValidateConfig{}
+
+	if err := redConf.Watch(&conf); err != nil {
+		t.Error(err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	eventsC := redConf.Events(ctx, 10)
+	closedC := redConf.Events(context.Background(), 10)
+
+	storage.Set("NS", "TestValidateConfig:Port", "8080")
+
+	select {
+	case event := <-eventsC:
+		if event.Key != "TestValidateConfig:Port" || event.AfterValue != 8080 {
+			t.Errorf("unexcepted event: %#v", event)
+			return
+		}
+	case <-time.After(time.Second):
+		t.Error("wait event timeout")
+		return
+	}
+
+	cancel()
+
+	for range eventsC {
+	}
+
+	redConf.Close()
+
+	timeout := time.After(time.Second)
+
+	for {
+		select {
+		case _, ok := <-closedC:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Error("the events channel should be closed while RedConf closed")
+			return
+		}
+	}
+}
+
+func TestRedConfEventsBufferFull(t *testing.T) {
+
+	redConf, storage := newMemoryRedConf(t, "NS")
+	defer redConf.Close()
+
+	conf := TestValidateConfig{}
+
+	if err := redConf.Watch(&conf); err != nil {
+		t.Error(err)
+		return
+	}
+
+	var errEvents []ErrorEvent
+	redConf.SubscribeErrors(func(event ErrorEvent) {
+		errEvents = append(errEvents, event)
+	})
+
+	// the channel is not read, the updates should not be blocked
+	eventsC := redConf.Events(context.Background(), 1)
+
+	storage.Set("NS", "TestValidateConfig:Port", "8080")
+	storage.Set("NS", "TestValidateConfig:Port", "8081")
+
+	if conf.Port != 8081 {
+		t.Errorf("the update should not wait the events channel, got: %#v", conf)
+		return
+	}
+
+	if len(errEvents) != 1 || errEvents[0].Stage != ErrorStageDispatch || errEvents[0].Error != ErrEventsBufferFull {
+		t.Errorf("the dropped event should be reported, got: %#v", errEvents)
+		return
+	}
+
+	if event := <-eventsC; event.AfterValue != 8080 {
+		t.Errorf("unexcepted event: %#v", event)
+		return
+	}
+}
+
+func TestRedConfSubscriberIsolation(t *testing.T) {
+
+	redConf, storage := newMemoryRedConf(t, "NS")
+	defer redConf.Close()
+
+	conf := TestValidateConfig{}
+
+	if err := redConf.Watch(&conf); err != nil {
+		t.Error(err)
+		return
+	}
+
+	errEventsC := make(chan ErrorEvent, 10)
+
+	redConf.SubscribeErrors(func(event ErrorEvent) {
+		errEventsC <- event
+	})
+
+	panicSub := redConf.Subscribe(func(event OnValueChangedEvent) {
+		panic("bad plugin")
+	})
+
+	called := false
+	redConf.Subscribe(func(event OnValueChangedEvent) {
+		called = true
+	})
+
+	storage.Set("NS", "TestValidateConfig:Port", "8080")
+
+	if conf.Port != 8080 {
+		t.Errorf("the update should not be broken by the panic, got: %d", conf.Port)
+		return
+	}
+
+	select {
+	case event := <-errEventsC:
+		if event.Stage != ErrorStageSubscriber || event.Key != "TestValidateConfig:Port" {
+			t.Errorf("unexcepted error event: %#v", event)
+			return
+		}
+	default:
+		t.Error("the panic should be reported")
+		return
+	}
+
+	panicSub.Unsubscribe()
+
+	release := make(chan bool)
+	defer close(release)
+
+	redConf.Subscribe(func(event OnValueChangedEvent) {
+		<-release
+	}).SetTimeout(time.Millisecond * 50)
+
+	asyncSub := redConf.Subscribe(func(event OnValueChangedEvent) {
+		<-release
+	}).SetAsync(true)
+	defer asyncSub.Unsubscribe()
+
+	start := time.Now()
+
+	storage.Set("NS", "TestValidateConfig:Port", "8081")
+
+	if elapsed := time.Since(start); conf.Port != 8081 || elapsed > time.Second {
+		t.Errorf("the update should not wait the slow subscribers, elapsed: %s", elapsed)
+		return
+	}
+
+	select {
+	case event := <-errEventsC:
+		if event.Stage != ErrorStageSubscriber {
+			t.Errorf("unexcepted error event: %#v", event)
+			return
+		}
+	default:
+		t.Error("the timeout should be reported")
+		return
+	}
+
+	if !called {
+		t.Error("the other subscribers should be called")
+		return
+	}
+}
+
+var testHookCalls []string
+
+type TestHookServerConfig struct {
+	Host string
+	Port int
+}
+
+func (p *TestHookServerConfig) OnConfigChanged(changedKeys []string) {
+	testHookCalls = append(testHookCalls, fmt.Sprintf("server:%v", changedKeys))
+}
+
+type TestHookConfig struct {
+	Server TestHookServerConfig
+	Name   string
+}
+
+func (p *TestHookConfig) OnConfigChanged(changedKeys []string) {
+	testHookCalls = append(testHookCalls, fmt.Sprintf("root:%v", changedKeys))
+}
+
+func TestRedConfChangedHook(t *testing.T) {
+
+	redConf, storage := newMemoryRedConf(t, "NS")
+	defer redConf.Close()
+
+	conf := TestHookConfig{}
+
+	if err := redConf.Watch(&conf); err != nil {
+		t.Error(err)
+		return
+	}
+
+	testHookCalls = nil
+
+	storage.SetMulti("NS", map[string]interface{}{
+		"TestHookConfig:Server:Host": "127.0.0.1",
+		"TestHookConfig:Server:Port": "8080",
+	})
+
+	storage.Set("NS", "TestHookConfig:Name", "app")
+
+	excepted := []string{
+		"server:[TestHookConfig:Server:Host TestHookConfig:Server:Port]",
+		"root:[TestHookConfig:Server:Host TestHookConfig:Server:Port]",
+		"root:[TestHookConfig:Name]",
+	}
+
+	if !reflect.DeepEqual(testHookCalls, excepted) {
+		t.Errorf("unexcepted hook calls: %v", testHookCalls)
+		return
+	}
+}
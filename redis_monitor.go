@@ -1,6 +1,7 @@
 package redconf
 
 import (
+	"encoding/json"
 	"fmt"
 	"strings"
 	"sync"
@@ -16,14 +17,33 @@ const (
 	DefaultSubscribeChannel = "REDCONF:ONCHANGED"
 )
 
+const (
+	// RedisMonitorModeChannel subscribe the channel which the writers PUBLISH the changed key to
+	RedisMonitorModeChannel = "channel"
+	// RedisMonitorModeKeyspace subscribe the keyspace notifications of the namespace,
+	// any SET or DEL of the keys will notify without PUBLISH, the notify-keyspace-events
+	// should contain the class h for the HSET of map fields in hash mode, such as K$hgx
+	RedisMonitorModeKeyspace = "keyspace"
+)
+
 type RedisMonitor struct {
 	address  string
 	password string
 	channel  string
+	mode     string
+	db       int
 	pool     *redis.Pool
 
-	watchingNamespace map[string]bool
+	notifyKeyspaceEvents string
+
+	watchingNamespace map[string]*redisWatcher
 	watchLocker       sync.Mutex
+	closed            bool
+}
+
+type redisWatcher struct {
+	conn    redis.Conn
+	stopped bool
 }
 
 func init() {
@@ -35,9 +55,15 @@ func NewRedisMonitor(opts Options) (monitor Monitor, err error) {
 	address := ""
 	password := ""
 	channel := ""
+	mode := ""
+	db := 0
+	notifyKeyspaceEvents := ""
 
 	opts.Get("address", &address)
 	opts.Get("password", &password)
+	opts.Get("mode", &mode)
+	opts.Get("db", &db)
+	opts.Get("notify_keyspace_events", &notifyKeyspaceEvents)
 
 	if exist := opts.Get("channel", &channel); !exist {
 		channel = DefaultSubscribeChannel
@@ -47,11 +73,23 @@ func NewRedisMonitor(opts Options) (monitor Monitor, err error) {
 		address = "localhost:6379"
 	}
 
+	if mode == "" {
+		mode = RedisMonitorModeChannel
+	}
+
+	if mode != RedisMonitorModeChannel && mode != RedisMonitorModeKeyspace {
+		err = fmt.Errorf("redconf: unknown redis monitor mode of %s", mode)
+		return
+	}
+
 	m := &RedisMonitor{
-		address:           address,
-		password:          password,
-		channel:           channel,
-		watchingNamespace: make(map[string]bool),
+		address:              address,
+		password:             password,
+		channel:              channel,
+		mode:                 mode,
+		db:                   db,
+		notifyKeyspaceEvents: notifyKeyspaceEvents,
+		watchingNamespace:    make(map[string]*redisWatcher),
 	}
 
 	m.pool = redis.NewPool(m.getRedisConn, 0)
@@ -66,6 +104,11 @@ func (p *RedisMonitor) Watch(namespace string, callback KeyContentChangedCallbac
 	p.watchLocker.Lock()
 	defer p.watchLocker.Unlock()
 
+	if p.closed {
+		err = fmt.Errorf("redconf: redis monitor already closed")
+		return
+	}
+
 	var exist bool
 
 	if _, exist = p.watchingNamespace[namespace]; exist {
@@ -73,48 +116,91 @@ func (p *RedisMonitor) Watch(namespace string, callback KeyContentChangedCallbac
 		return
 	}
 
-	go p.watchNamespace(namespace, callback, onError)
+	var conn redis.Conn
+	if conn, err = p.subscribe(namespace); err != nil {
+		return
+	}
+
+	watcher := &redisWatcher{conn: conn}
+
+	p.watchingNamespace[namespace] = watcher
+
+	go p.watchNamespace(namespace, watcher, callback, onError)
+
+	return
+}
+
+func (p *RedisMonitor) Unwatch(namespace string) (err error) {
+
+	p.watchLocker.Lock()
+	defer p.watchLocker.Unlock()
+
+	if watcher, exist := p.watchingNamespace[namespace]; exist {
+		delete(p.watchingNamespace, namespace)
+		err = watcher.stop()
+	}
 
 	return
 }
 
-func (p *RedisMonitor) watchNamespace(namespace string, callback KeyContentChangedCallback, onError OnWatchingError) {
+func (p *RedisMonitor) Close() (err error) {
+
+	p.watchLocker.Lock()
+	defer p.watchLocker.Unlock()
+
+	if p.closed {
+		return
+	}
+
+	p.closed = true
+
+	for namespace, watcher := range p.watchingNamespace {
+		delete(p.watchingNamespace, namespace)
+		watcher.stop()
+	}
+
+	err = p.pool.Close()
+
+	return
+}
+
+func (p *RedisMonitor) watchNamespace(namespace string, watcher *redisWatcher, callback KeyContentChangedCallback, onError OnWatchingError) {
 
 	var err error
 
 	defer func() {
 		p.watchLocker.Lock()
-		delete(p.watchingNamespace, namespace)
+		if p.watchingNamespace[namespace] == watcher {
+			delete(p.watchingNamespace, namespace)
+		}
+		stopped := watcher.stopped
 		p.watchLocker.Unlock()
 
+		if stopped {
+			return
+		}
+
 		if err != nil && onError != nil {
 			go onError(namespace, err)
 		}
 	}()
 
-	var conn redis.Conn
-	if conn, err = p.getRedisConn(); err != nil {
-		return
-	}
+	defer watcher.conn.Close()
 
-	sub := &redis.PubSubConn{Conn: conn}
-
-	if err = sub.Subscribe(p.channel); err != nil {
-		return
-	}
+	sub := &redis.PubSubConn{Conn: watcher.conn}
 
 	for {
 		switch v := sub.Receive().(type) {
 		case redis.Message:
 			if callback != nil {
-				key := string(v.Data)
-				if strings.HasPrefix(key, namespace) {
-					if namespace != "" {
-						key = strings.TrimPrefix(key, namespace+":")
-					}
-					if key != "" {
-						go callback(namespace, key)
-					}
+				if keys := trimRedisNamespaceKeys(namespace, parseRedisChangedKeys(v.Data)); len(keys) > 0 {
+					callback(namespace, keys...)
+				}
+			}
+		case redis.PMessage:
+			if callback != nil {
+				if key, ok := trimRedisNamespace(namespace, strings.TrimPrefix(v.Channel, p.keyspacePrefix())); ok {
+					callback(namespace, key)
 				}
 			}
 		case error:
@@ -124,6 +210,117 @@ func (p *RedisMonitor) watchNamespace(namespace string, callback KeyContentChang
 	}
 }
 
+// subscribe the channel or keyspace, and wait the subscription confirmed,
+// so the changes after Watch returned will not be missed
+func (p *RedisMonitor) subscribe(namespace string) (conn redis.Conn, err error) {
+
+	if conn, err = p.getRedisConn(); err != nil {
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			conn.Close()
+			conn = nil
+		}
+	}()
+
+	sub := &redis.PubSubConn{Conn: conn}
+
+	if p.mode == RedisMonitorModeKeyspace {
+		if p.notifyKeyspaceEvents != "" {
+			if _, err = conn.Do("CONFIG", "SET", "notify-keyspace-events", p.notifyKeyspaceEvents); err != nil {
+				return
+			}
+		}
+
+		if err = sub.PSubscribe(p.keyspacePrefix() + redisNamespacePrefix(namespace) + "*"); err != nil {
+			return
+		}
+	} else {
+		if err = sub.Subscribe(p.channel); err != nil {
+			return
+		}
+	}
+
+	switch v := sub.Receive().(type) {
+	case redis.Subscription:
+	case error:
+		err = v
+	default:
+		err = fmt.Errorf("redconf: unexcepted reply while subscribing: %v", v)
+	}
+
+	return
+}
+
+func (p *RedisMonitor) keyspacePrefix() string {
+	return fmt.Sprintf("__keyspace@%d__:", p.db)
+}
+
+func redisNamespacePrefix(namespace string) string {
+	if namespace == "" {
+		return ""
+	}
+
+	return namespace + ":"
+}
+
+func trimRedisNamespace(namespace, redisKey string) (key string, ok bool) {
+
+	prefix := redisNamespacePrefix(namespace)
+
+	if !strings.HasPrefix(redisKey, prefix) {
+		return
+	}
+
+	key = strings.TrimPrefix(redisKey, prefix)
+
+	ok = key != ""
+
+	return
+}
+
+func trimRedisNamespaceKeys(namespace string, redisKeys []string) (keys []string) {
+	for _, redisKey := range redisKeys {
+		if key, ok := trimRedisNamespace(namespace, redisKey); ok {
+			keys = append(keys, key)
+		}
+	}
+
+	return
+}
+
+// parseRedisChangedKeys parse the message of changed keys, it is a key, or a JSON array
+// of keys like ["GOGAP:AppConfig:Server:Host","GOGAP:AppConfig:Server:Port"] which
+// changed together and will be applied as one change set
+func parseRedisChangedKeys(data []byte) (keys []string) {
+
+	str := strings.TrimSpace(string(data))
+
+	if strings.HasPrefix(str, "[") {
+		if err := json.Unmarshal([]byte(str), &keys); err == nil {
+			return
+		}
+	}
+
+	if str != "" {
+		keys = []string{str}
+	}
+
+	return
+}
+
+func (p *redisWatcher) stop() (err error) {
+	p.stopped = true
+
+	if p.conn != nil {
+		err = p.conn.Close()
+	}
+
+	return
+}
+
 func (p *RedisMonitor) getRedisConn() (conn redis.Conn, e error) {
 
 	conn, e = redis.Dial("tcp", p.address)
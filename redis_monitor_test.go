@@ -0,0 +1,50 @@
+package redconf
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRedisChangedKeys(t *testing.T) {
+
+	cases := []struct {
+		data string
+		keys []string
+	}{
+		{"GOGAP:AppConfig:Server:Host", []string{"GOGAP:AppConfig:Server:Host"}},
+		{" GOGAP:AppConfig:Server:Host\n", []string{"GOGAP:AppConfig:Server:Host"}},
+		{`["GOGAP:AppConfig:Server:Host","GOGAP:AppConfig:Server:Port"]`, []string{"GOGAP:AppConfig:Server:Host", "GOGAP:AppConfig:Server:Port"}},
+		{"[GOGAP:AppConfig:Server:Host", []string{"[GOGAP:AppConfig:Server:Host"}},
+		{"", nil},
+		{"  ", nil},
+	}
+
+	for _, c := range cases {
+		if keys := parseRedisChangedKeys([]byte(c.data)); !reflect.DeepEqual(keys, c.keys) {
+			t.Errorf("parse %q: expect %v, got %v", c.data, c.keys, keys)
+		}
+	}
+}
+
+func TestTrimRedisNamespaceKeys(t *testing.T) {
+
+	redisKeys := []string{
+		"GOGAP:AppConfig:Server:Host",
+		"OTHER:AppConfig:Server:Host",
+		"GOGAP:",
+		"GOGAPAppConfig:Server:Port",
+		"GOGAP:AppConfig:Server:Port",
+	}
+
+	keys := trimRedisNamespaceKeys("GOGAP", redisKeys)
+
+	if expect := []string{"AppConfig:Server:Host", "AppConfig:Server:Port"}; !reflect.DeepEqual(keys, expect) {
+		t.Errorf("expect %v, got %v", expect, keys)
+	}
+
+	keys = trimRedisNamespaceKeys("", []string{"AppConfig:Server:Host", ""})
+
+	if expect := []string{"AppConfig:Server:Host"}; !reflect.DeepEqual(keys, expect) {
+		t.Errorf("expect %v, got %v", expect, keys)
+	}
+}
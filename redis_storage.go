@@ -1,11 +1,26 @@
 package redconf
 
 import (
+	"fmt"
+	"strings"
+
 	"github.com/garyburd/redigo/redis"
 )
 
 var (
-	_ Storage = (*RedisStorage)(nil)
+	_ Storage      = (*RedisStorage)(nil)
+	_ BatchStorage = (*RedisStorage)(nil)
+	_ ScanStorage  = (*RedisStorage)(nil)
+	_ HashStorage  = (*RedisStorage)(nil)
+)
+
+const (
+	redisMGetBatchSize = 500
+	redisScanCount     = 500
+)
+
+var (
+	redisGlobEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
 )
 
 type RedisStorage struct {
@@ -65,6 +80,7 @@ func (p *RedisStorage) getRedisKey(namespace, key string) string {
 func (p *RedisStorage) Set(namespace, key string, val interface{}) (err error) {
 
 	conn := p.pool.Get()
+	defer conn.Close()
 
 	if _, err = conn.Do("SET", p.getRedisKey(namespace, key), val); err != nil {
 		return
@@ -76,6 +92,7 @@ func (p *RedisStorage) Set(namespace, key string, val interface{}) (err error) {
 func (p *RedisStorage) Get(namespace, key string) (ret interface{}, err error) {
 
 	conn := p.pool.Get()
+	defer conn.Close()
 
 	var reply interface{}
 
@@ -85,6 +102,7 @@ func (p *RedisStorage) Get(namespace, key string) (ret interface{}, err error) {
 
 	if ret, err = redis.String(reply, err); err != nil {
 		if err == redis.ErrNil {
+			ret = nil
 			err = nil
 		}
 	}
@@ -92,6 +110,110 @@ func (p *RedisStorage) Get(namespace, key string) (ret interface{}, err error) {
 	return
 }
 
+func (p *RedisStorage) GetMulti(namespace string, keys []string) (rets []interface{}, err error) {
+
+	conn := p.pool.Get()
+	defer conn.Close()
+
+	for start := 0; start < len(keys); start += redisMGetBatchSize {
+		end := start + redisMGetBatchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+
+		var args []interface{}
+		for _, key := range keys[start:end] {
+			args = append(args, p.getRedisKey(namespace, key))
+		}
+
+		var replies []interface{}
+		if replies, err = redis.Values(conn.Do("MGET", args...)); err != nil {
+			return
+		}
+
+		for _, reply := range replies {
+			var ret interface{}
+			if reply != nil {
+				if ret, err = redis.String(reply, nil); err != nil {
+					return
+				}
+			}
+			rets = append(rets, ret)
+		}
+	}
+
+	return
+}
+
+// Scan the keys by SCAN with MATCH, so the redis is not blocked like KEYS
+func (p *RedisStorage) Scan(namespace, prefix string) (keys []string, err error) {
+
+	conn := p.pool.Get()
+	defer conn.Close()
+
+	redisPrefix := p.getRedisKey(namespace, prefix)
+	pattern := redisGlobEscaper.Replace(redisPrefix) + "*"
+
+	cursor := "0"
+
+	for {
+		var reply []interface{}
+		if reply, err = redis.Values(conn.Do("SCAN", cursor, "MATCH", pattern, "COUNT", redisScanCount)); err != nil {
+			return
+		}
+
+		if len(reply) != 2 {
+			err = fmt.Errorf("redconf: unexpected reply of redis SCAN")
+			return
+		}
+
+		if cursor, err = redis.String(reply[0], nil); err != nil {
+			return
+		}
+
+		var redisKeys []string
+		if redisKeys, err = redis.Strings(reply[1], nil); err != nil {
+			return
+		}
+
+		for _, redisKey := range redisKeys {
+			keys = append(keys, prefix+strings.TrimPrefix(redisKey, redisPrefix))
+		}
+
+		if cursor == "0" {
+			break
+		}
+	}
+
+	return
+}
+
+func (p *RedisStorage) GetHash(namespace, key string) (ret map[string]interface{}, err error) {
+
+	conn := p.pool.Get()
+	defer conn.Close()
+
+	var hash map[string]string
+	if hash, err = redis.StringMap(conn.Do("HGETALL", p.getRedisKey(namespace, key))); err != nil {
+		return
+	}
+
+	if len(hash) == 0 {
+		return
+	}
+
+	ret = make(map[string]interface{}, len(hash))
+	for k, v := range hash {
+		ret[k] = v
+	}
+
+	return
+}
+
+func (p *RedisStorage) Close() (err error) {
+	return p.pool.Close()
+}
+
 func (p *RedisStorage) getRedisConn() (conn redis.Conn, e error) {
 
 	conn, e = redis.Dial("tcp", p.address)
@@ -107,6 +229,7 @@ func (p *RedisStorage) getRedisConn() (conn redis.Conn, e error) {
 	}
 
 	if _, e = conn.Do("SELECT", p.db); e != nil {
+		conn.Close()
 		return
 	}
 
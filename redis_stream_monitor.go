@@ -0,0 +1,353 @@
+package redconf
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+var (
+	_ Monitor = (*RedisStreamMonitor)(nil)
+)
+
+const (
+	DefaultStreamName = "REDCONF:STREAM"
+
+	DefaultStreamBlockTimeout = time.Second * 5
+)
+
+// RedisStreamMonitor read the changed keys from redis stream, the writers should
+// XADD <stream> * key <namespace:key>, the last id is kept while reconnecting,
+// so the changes during the reconnecting will be replayed, and if the option of
+// consumer is set, the last id will be persisted to redis for the process restarting
+type RedisStreamMonitor struct {
+	address  string
+	password string
+	db       int
+	stream   string
+	consumer string
+	block    time.Duration
+	pool     *redis.Pool
+
+	lastIDs           map[string]string
+	watchingNamespace map[string]*redisWatcher
+	watchLocker       sync.Mutex
+	closed            bool
+}
+
+func init() {
+	RegisterMonitor("redis-stream", NewRedisStreamMonitor)
+}
+
+func NewRedisStreamMonitor(opts Options) (monitor Monitor, err error) {
+
+	address := ""
+	password := ""
+	db := 0
+	stream := ""
+	consumer := ""
+	block := DefaultStreamBlockTimeout
+
+	opts.Get("address", &address)
+	opts.Get("password", &password)
+	opts.Get("db", &db)
+	opts.Get("consumer", &consumer)
+
+	if exist := opts.Get("stream", &stream); !exist || stream == "" {
+		stream = DefaultStreamName
+	}
+
+	if _, err = opts.GetDuration("block", &block); err != nil {
+		return
+	}
+
+	if address == "" {
+		address = "localhost:6379"
+	}
+
+	if block < time.Millisecond {
+		block = DefaultStreamBlockTimeout
+	}
+
+	m := &RedisStreamMonitor{
+		address:           address,
+		password:          password,
+		db:                db,
+		stream:            stream,
+		consumer:          consumer,
+		block:             block,
+		lastIDs:           make(map[string]string),
+		watchingNamespace: make(map[string]*redisWatcher),
+	}
+
+	m.pool = redis.NewPool(m.getRedisConn, 0)
+
+	monitor = m
+
+	return
+}
+
+func (p *RedisStreamMonitor) Watch(namespace string, callback KeyContentChangedCallback, onError OnWatchingError) (err error) {
+
+	p.watchLocker.Lock()
+	defer p.watchLocker.Unlock()
+
+	if p.closed {
+		err = fmt.Errorf("redconf: redis stream monitor already closed")
+		return
+	}
+
+	if _, exist := p.watchingNamespace[namespace]; exist {
+		err = fmt.Errorf("redconf: namespace of %s already in watching", namespace)
+		return
+	}
+
+	var conn redis.Conn
+	if conn, err = p.getRedisConn(); err != nil {
+		return
+	}
+
+	// resolve the last id before returning, the entries after Watch returned will not be missed
+	var lastID string
+	if lastID, err = p.getLastID(conn, namespace); err != nil {
+		conn.Close()
+		return
+	}
+
+	watcher := &redisWatcher{conn: conn}
+
+	p.watchingNamespace[namespace] = watcher
+
+	go p.watchNamespace(namespace, watcher, lastID, callback, onError)
+
+	return
+}
+
+func (p *RedisStreamMonitor) Unwatch(namespace string) (err error) {
+
+	p.watchLocker.Lock()
+	defer p.watchLocker.Unlock()
+
+	if watcher, exist := p.watchingNamespace[namespace]; exist {
+		delete(p.watchingNamespace, namespace)
+		err = watcher.stop()
+	}
+
+	return
+}
+
+func (p *RedisStreamMonitor) Close() (err error) {
+
+	p.watchLocker.Lock()
+	defer p.watchLocker.Unlock()
+
+	if p.closed {
+		return
+	}
+
+	p.closed = true
+
+	for namespace, watcher := range p.watchingNamespace {
+		delete(p.watchingNamespace, namespace)
+		watcher.stop()
+	}
+
+	err = p.pool.Close()
+
+	return
+}
+
+func (p *RedisStreamMonitor) watchNamespace(namespace string, watcher *redisWatcher, lastID string, callback KeyContentChangedCallback, onError OnWatchingError) {
+
+	var err error
+
+	defer func() {
+		p.watchLocker.Lock()
+		if p.watchingNamespace[namespace] == watcher {
+			delete(p.watchingNamespace, namespace)
+		}
+		stopped := watcher.stopped
+		p.watchLocker.Unlock()
+
+		if stopped {
+			return
+		}
+
+		if err != nil && onError != nil {
+			go onError(namespace, err)
+		}
+	}()
+
+	conn := watcher.conn
+
+	defer conn.Close()
+
+	for {
+		var reply []interface{}
+		reply, err = redis.Values(conn.Do("XREAD", "COUNT", 100, "BLOCK", int64(p.block/time.Millisecond), "STREAMS", p.stream, lastID))
+		if err == redis.ErrNil {
+			err = nil
+			continue
+		} else if err != nil {
+			return
+		}
+
+		var changes [][]string
+		if lastID, changes, err = p.parseEntries(reply, namespace, lastID); err != nil {
+			return
+		}
+
+		if callback != nil {
+			for _, keys := range changes {
+				callback(namespace, keys...)
+			}
+		}
+
+		if err = p.setLastID(conn, namespace, lastID); err != nil {
+			return
+		}
+	}
+}
+
+func (p *RedisStreamMonitor) lastIDKey(namespace string) string {
+	return p.stream + ":CONSUMER:" + p.consumer + ":" + namespace
+}
+
+// getLastID return the id kept while reconnecting, or persisted by consumer,
+// otherwise the last id of the stream, for only reading the new entries,
+// it should be called with watchLocker locked
+func (p *RedisStreamMonitor) getLastID(conn redis.Conn, namespace string) (lastID string, err error) {
+
+	if lastID = p.lastIDs[namespace]; lastID != "" {
+		return
+	}
+
+	if p.consumer != "" {
+		if lastID, err = redis.String(conn.Do("GET", p.lastIDKey(namespace))); err != nil && err != redis.ErrNil {
+			return
+		}
+		err = nil
+
+		if lastID != "" {
+			return
+		}
+	}
+
+	var entries []interface{}
+	if entries, err = redis.Values(conn.Do("XREVRANGE", p.stream, "+", "-", "COUNT", 1)); err != nil {
+		return
+	}
+
+	lastID = "0-0"
+
+	if len(entries) > 0 {
+		var entry []interface{}
+		if entry, err = redis.Values(entries[0], nil); err != nil {
+			return
+		}
+
+		if lastID, err = redis.String(entry[0], nil); err != nil {
+			return
+		}
+	}
+
+	p.lastIDs[namespace] = lastID
+
+	return
+}
+
+func (p *RedisStreamMonitor) setLastID(conn redis.Conn, namespace, lastID string) (err error) {
+
+	p.watchLocker.Lock()
+	p.lastIDs[namespace] = lastID
+	p.watchLocker.Unlock()
+
+	if p.consumer != "" {
+		_, err = conn.Do("SET", p.lastIDKey(namespace), lastID)
+	}
+
+	return
+}
+
+// parseEntries return the changed keys of each entry, the entry has the field key of
+// one changed key, or the field keys of a JSON array which changed together
+func (p *RedisStreamMonitor) parseEntries(reply []interface{}, namespace, lastID string) (newLastID string, changes [][]string, err error) {
+
+	newLastID = lastID
+
+	for _, streamReply := range reply {
+
+		var stream []interface{}
+		if stream, err = redis.Values(streamReply, nil); err != nil {
+			return
+		}
+
+		if len(stream) != 2 {
+			err = fmt.Errorf("redconf: unexcepted reply of stream %s", p.stream)
+			return
+		}
+
+		var entries []interface{}
+		if entries, err = redis.Values(stream[1], nil); err != nil {
+			return
+		}
+
+		for _, entryReply := range entries {
+			var entry []interface{}
+			if entry, err = redis.Values(entryReply, nil); err != nil {
+				return
+			}
+
+			if len(entry) != 2 {
+				err = fmt.Errorf("redconf: unexcepted entry of stream %s", p.stream)
+				return
+			}
+
+			if newLastID, err = redis.String(entry[0], nil); err != nil {
+				return
+			}
+
+			var fields map[string]string
+			if fields, err = redis.StringMap(entry[1], nil); err != nil {
+				return
+			}
+
+			var redisKeys []string
+			if strKeys, exist := fields["keys"]; exist {
+				redisKeys = parseRedisChangedKeys([]byte(strKeys))
+			} else if key, exist := fields["key"]; exist {
+				redisKeys = []string{key}
+			}
+
+			if keys := trimRedisNamespaceKeys(namespace, redisKeys); len(keys) > 0 {
+				changes = append(changes, keys)
+			}
+		}
+	}
+
+	return
+}
+
+func (p *RedisStreamMonitor) getRedisConn() (conn redis.Conn, e error) {
+
+	conn, e = redis.Dial("tcp", p.address)
+	if e != nil {
+		return
+	}
+
+	if p.password != "" {
+		if _, e = conn.Do("AUTH", p.password); e != nil {
+			conn.Close()
+			return
+		}
+	}
+
+	if _, e = conn.Do("SELECT", p.db); e != nil {
+		conn.Close()
+		return
+	}
+
+	return
+}
@@ -0,0 +1,74 @@
+package redconf
+
+import (
+	"reflect"
+	"testing"
+)
+
+func testStreamEntry(id string, fields ...string) interface{} {
+	var values []interface{}
+	for _, field := range fields {
+		values = append(values, []byte(field))
+	}
+
+	return []interface{}{[]byte(id), values}
+}
+
+func TestRedisStreamMonitorParseEntries(t *testing.T) {
+
+	monitor := &RedisStreamMonitor{stream: "REDCONF:STREAM"}
+
+	reply := []interface{}{
+		[]interface{}{
+			[]byte("REDCONF:STREAM"),
+			[]interface{}{
+				testStreamEntry("1-0", "key", "GOGAP:AppConfig:Server:Host"),
+				testStreamEntry("2-0", "keys", `["GOGAP:AppConfig:Server:Host","GOGAP:AppConfig:Server:Port"]`),
+				testStreamEntry("3-0", "key", "OTHER:AppConfig:Server:Host"),
+				testStreamEntry("4-0", "unknown", "GOGAP:AppConfig:Server:Host"),
+			},
+		},
+	}
+
+	lastID, changes, err := monitor.parseEntries(reply, "GOGAP", "0-0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if lastID != "4-0" {
+		t.Errorf("expect last id 4-0, got %s", lastID)
+	}
+
+	expect := [][]string{
+		{"AppConfig:Server:Host"},
+		{"AppConfig:Server:Host", "AppConfig:Server:Port"},
+	}
+
+	if !reflect.DeepEqual(changes, expect) {
+		t.Errorf("expect %v, got %v", expect, changes)
+	}
+
+	if lastID, changes, err = monitor.parseEntries(nil, "GOGAP", "4-0"); err != nil || lastID != "4-0" || len(changes) != 0 {
+		t.Errorf("expect the last id kept without entries, got %s %v %v", lastID, changes, err)
+	}
+
+	badReply := []interface{}{
+		[]interface{}{[]byte("REDCONF:STREAM")},
+	}
+
+	if _, _, err = monitor.parseEntries(badReply, "GOGAP", "0-0"); err == nil {
+		t.Error("expect error of unexcepted reply")
+	}
+
+	badEntry := []interface{}{
+		[]interface{}{
+			[]byte("REDCONF:STREAM"),
+			[]interface{}{[]interface{}{[]byte("5-0")}},
+		},
+	}
+
+	if _, _, err = monitor.parseEntries(badEntry, "GOGAP", "0-0"); err == nil {
+		t.Error("expect error of unexcepted entry")
+	}
+}
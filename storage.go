@@ -7,11 +7,30 @@ import (
 
 type NewStorageFunc func(opts Options) (storage Storage, err error)
 
+// Storage should return nil value for the missing keys, then the default value in tag will be used
 type Storage interface {
 	Set(namespace, key string, val interface{}) (err error)
 	Get(namespace, key string) (ret interface{}, err error)
 }
 
+// BatchStorage is optional for the storages which could get many keys in one round trip,
+// the rets is in the same order of keys
+type BatchStorage interface {
+	GetMulti(namespace string, keys []string) (rets []interface{}, err error)
+}
+
+// ScanStorage is optional for the map fields in keys mode, it returns the keys which
+// start with the prefix, such as AppConfig:Limits:tenant_a for the prefix AppConfig:Limits:
+type ScanStorage interface {
+	Scan(namespace, prefix string) (keys []string, err error)
+}
+
+// HashStorage is optional for the map fields in hash mode, it returns all the fields and
+// values of the hash, and nil for the missing hash
+type HashStorage interface {
+	GetHash(namespace, key string) (ret map[string]interface{}, err error)
+}
+
 var (
 	storageDrivers = make(map[string]NewStorageFunc)
 
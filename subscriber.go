@@ -0,0 +1,448 @@
+package redconf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Subscription is returned by the Subscribe methods, Unsubscribe it while the
+// subscribers are not needed any more, such as the short-lived components and tests
+type Subscription struct {
+	redConf      *RedConf
+	subscribers  []interface{}
+	unsubscribed int32
+
+	timeout int64
+	async   int32
+}
+
+// SetTimeout set the timeout of calling the subscribers, the timeout is reported
+// to SubscribeErrors with stage subscriber, and the next update will not wait it,
+// but the subscriber is not interrupted, 0 is waiting it returned
+func (p *Subscription) SetTimeout(timeout time.Duration) *Subscription {
+	atomic.StoreInt64(&p.timeout, int64(timeout))
+	return p
+}
+
+// SetAsync call the subscribers in new goroutines, the update will not wait them,
+// and the events may be received out of order
+func (p *Subscription) SetAsync(async bool) *Subscription {
+	var v int32
+	if async {
+		v = 1
+	}
+	atomic.StoreInt32(&p.async, v)
+	return p
+}
+
+// Unsubscribe remove the subscribers, they will not be called after Unsubscribe
+// returned, except the one which is running, it is safe to call it many times
+func (p *Subscription) Unsubscribe() {
+
+	atomic.StoreInt32(&p.unsubscribed, 1)
+
+	p.redConf.subscribersLock.Lock()
+	defer p.redConf.subscribersLock.Unlock()
+
+	for _, s := range p.subscribers {
+		switch v := s.(type) {
+		case *valueSubscriber:
+			delete(p.redConf.subscriber, v)
+		case *errorSubscriber:
+			delete(p.redConf.errSubscriber, v)
+		case *changeSetSubscriber:
+			delete(p.redConf.changeSetSubscriber, v)
+		}
+	}
+}
+
+func (p *Subscription) active() bool {
+	return atomic.LoadInt32(&p.unsubscribed) == 0
+}
+
+// valueSubscriber receive the events of all keys, or the events of the key,
+// or the events of the keys under the prefix
+type valueSubscriber struct {
+	sub    *Subscription
+	fn     OnValueChangedSubscriber
+	key    string
+	prefix string
+}
+
+func (p *valueSubscriber) match(key string) bool {
+	if p.key != "" {
+		return p.key == key
+	}
+
+	if p.prefix != "" {
+		return key == p.prefix || strings.HasPrefix(key, p.prefix+":")
+	}
+
+	return true
+}
+
+type errorSubscriber struct {
+	sub *Subscription
+	fn  OnErrorSubscriber
+}
+
+type changeSetSubscriber struct {
+	sub *Subscription
+	fn  OnChangeSetSubscriber
+}
+
+func (p *RedConf) Subscribe(subscribers ...OnValueChangedSubscriber) *Subscription {
+	return p.addSubscribers("", "", subscribers)
+}
+
+// SubscribeKey subscribe the events of the key, such as AppConfig:Server:Port
+func (p *RedConf) SubscribeKey(key string, subscribers ...OnValueChangedSubscriber) *Subscription {
+	return p.addSubscribers(key, "", subscribers)
+}
+
+// SubscribePrefix subscribe the events of the keys under the prefix, the prefix is
+// matched by the whole segments, AppConfig:Log matches AppConfig:Log:Level but not
+// AppConfig:Logger, and the tailing :* is allowed, such as AppConfig:Server:*
+func (p *RedConf) SubscribePrefix(prefix string, subscribers ...OnValueChangedSubscriber) *Subscription {
+	prefix = strings.TrimSuffix(strings.TrimSuffix(prefix, "*"), ":")
+	return p.addSubscribers("", prefix, subscribers)
+}
+
+// SubscribeStruct subscribe the events of the keys in the struct, the val should be
+// the pointer of a watching config, or the pointer of a nested struct in it,
+// such as &appConf.Server or appConf.Redis while it is a pointer
+func (p *RedConf) SubscribeStruct(val interface{}, subscribers ...OnValueChangedSubscriber) (sub *Subscription, err error) {
+
+	prefix, found := p.structKeyPrefix(val)
+	if !found {
+		err = errors.New("redconf: the struct to subscribe is not watched")
+		return
+	}
+
+	sub = p.addSubscribers("", prefix, subscribers)
+
+	return
+}
+
+func (p *RedConf) addSubscribers(key, prefix string, subscribers []OnValueChangedSubscriber) *Subscription {
+	p.subscribersLock.Lock()
+	defer p.subscribersLock.Unlock()
+
+	sub := &Subscription{redConf: p}
+
+	for _, s := range subscribers {
+		if s == nil {
+			continue
+		}
+		vs := &valueSubscriber{sub: sub, fn: s, key: key, prefix: prefix}
+		sub.subscribers = append(sub.subscribers, vs)
+		p.subscriber[vs] = true
+	}
+
+	return sub
+}
+
+func (p *RedConf) SubscribeErrors(subscribers ...OnErrorSubscriber) *Subscription {
+	p.subscribersLock.Lock()
+	defer p.subscribersLock.Unlock()
+
+	sub := &Subscription{redConf: p}
+
+	for _, s := range subscribers {
+		if s == nil {
+			continue
+		}
+		es := &errorSubscriber{sub: sub, fn: s}
+		sub.subscribers = append(sub.subscribers, es)
+		p.errSubscriber[es] = true
+	}
+
+	return sub
+}
+
+// SubscribeChangeSet subscribe the changes applied together, the OnValueChangedSubscriber
+// still receive the events of the change set one by one
+func (p *RedConf) SubscribeChangeSet(subscribers ...OnChangeSetSubscriber) *Subscription {
+	p.subscribersLock.Lock()
+	defer p.subscribersLock.Unlock()
+
+	sub := &Subscription{redConf: p}
+
+	for _, s := range subscribers {
+		if s == nil {
+			continue
+		}
+		cs := &changeSetSubscriber{sub: sub, fn: s}
+		sub.subscribers = append(sub.subscribers, cs)
+		p.changeSetSubscriber[cs] = true
+	}
+
+	return sub
+}
+
+// Events return a channel of the events, so the events could be selected with other
+// works in the main loop, the updates never wait the channel, while the buffer is full,
+// the event is dropped and reported to SubscribeErrors with stage dispatch, and the
+// channel will be closed while the ctx is done or the RedConf is closed
+func (p *RedConf) Events(ctx context.Context, bufferSize int) <-chan OnValueChangedEvent {
+
+	if bufferSize < 0 {
+		bufferSize = 0
+	}
+
+	eventsC := make(chan OnValueChangedEvent, bufferSize)
+
+	// the channel is closed with the lock held, so no event will be sent after closed
+	var lock sync.Mutex
+	closed := false
+
+	sub := p.Subscribe(func(event OnValueChangedEvent) {
+		lock.Lock()
+
+		dropped := false
+
+		if !closed {
+			select {
+			case eventsC <- event:
+			default:
+				dropped = true
+			}
+		}
+
+		lock.Unlock()
+
+		if dropped {
+			p.publishError(ErrorStageDispatch, event.Key, event.AfterValue, ErrEventsBufferFull)
+		}
+	})
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-p.ctx.Done():
+		}
+
+		sub.Unsubscribe()
+
+		lock.Lock()
+		closed = true
+		close(eventsC)
+		lock.Unlock()
+	}()
+
+	return eventsC
+}
+
+func (p *RedConf) publishChanges(result *changeResult) {
+
+	for _, event := range result.errors {
+		p.publishErrorEvent(event)
+	}
+
+	for _, h := range result.hooks {
+		hook := h
+		safeCall(func() { hook.hook.OnConfigChanged(hook.changedKeys) }, func(err error) {
+			p.publishError(ErrorStageHook, "", nil, err)
+		})
+	}
+
+	for _, event := range result.changeSet.Events {
+		p.publish(event)
+	}
+
+	if len(result.changeSet.Events) == 0 {
+		return
+	}
+
+	var subscribers []*changeSetSubscriber
+
+	p.subscribersLock.Lock()
+	for s := range p.changeSetSubscriber {
+		subscribers = append(subscribers, s)
+	}
+	p.subscribersLock.Unlock()
+
+	for _, s := range subscribers {
+		if s.sub.active() {
+			fn := s.fn
+			p.invoke(s.sub, func() { fn(result.changeSet) }, p.onSubscriberError(""))
+		}
+	}
+}
+
+func (p *RedConf) publish(event OnValueChangedEvent) {
+
+	var subscribers []*valueSubscriber
+
+	p.subscribersLock.Lock()
+	for s := range p.subscriber {
+		if s.match(event.Key) {
+			subscribers = append(subscribers, s)
+		}
+	}
+	p.subscribersLock.Unlock()
+
+	for _, s := range subscribers {
+		if s.sub.active() {
+			fn := s.fn
+			p.invoke(s.sub, func() { fn(event) }, p.onSubscriberError(event.Key))
+		}
+	}
+}
+
+func (p *RedConf) publishError(stage ErrorStage, key string, value interface{}, err error) {
+	p.publishErrorEvent(ErrorEvent{
+		Namespace: p.namespace,
+		Key:       key,
+		Value:     value,
+		Stage:     stage,
+		Error:     err,
+		Time:      time.Now(),
+	})
+}
+
+func (p *RedConf) publishErrorEvent(event ErrorEvent) {
+
+	var subscribers []*errorSubscriber
+
+	p.subscribersLock.Lock()
+	for s := range p.errSubscriber {
+		subscribers = append(subscribers, s)
+	}
+	p.subscribersLock.Unlock()
+
+	for _, s := range subscribers {
+		if s.sub.active() {
+			// the failure of error subscriber is not reported again
+			fn := s.fn
+			p.invoke(s.sub, func() { fn(event) }, nil)
+		}
+	}
+}
+
+// invoke call the subscriber with the panic recovered, and by the timeout and async
+// mode of the subscription, so one bad subscriber could not break the updates
+func (p *RedConf) invoke(sub *Subscription, call func(), onError func(err error)) {
+
+	if atomic.LoadInt32(&sub.async) == 1 {
+		go safeCall(call, onError)
+		return
+	}
+
+	timeout := time.Duration(atomic.LoadInt64(&sub.timeout))
+
+	if timeout <= 0 {
+		safeCall(call, onError)
+		return
+	}
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		safeCall(call, onError)
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+	case <-timer.C:
+		if onError != nil {
+			onError(fmt.Errorf("redconf: the subscriber is not returned in %s", timeout))
+		}
+	}
+}
+
+func (p *RedConf) onSubscriberError(key string) func(err error) {
+	return func(err error) {
+		p.publishError(ErrorStageSubscriber, key, nil, err)
+	}
+}
+
+func safeCall(call func(), onError func(err error)) {
+	defer func() {
+		if r := recover(); r != nil && onError != nil {
+			onError(fmt.Errorf("redconf: the subscriber panic, %v", r))
+		}
+	}()
+
+	call()
+}
+
+func (p *RedConf) structKeyPrefix(val interface{}) (prefix string, found bool) {
+
+	target := reflect.ValueOf(val)
+
+	if target.Kind() != reflect.Ptr || target.IsNil() || target.Elem().Kind() != reflect.Struct {
+		return
+	}
+
+	p.confLock.Lock()
+	defer p.confLock.Unlock()
+
+	for _, conf := range p.watching {
+		if conf.value == val {
+			return conf.name, true
+		}
+
+		if prefix, found = findStructKeyPrefix(reflect.ValueOf(conf.value), target, conf.name); found {
+			return
+		}
+	}
+
+	return
+}
+
+// findStructKeyPrefix walk the nested structs of the pointer val by the same rules as
+// getStructFields, and return the key prefix of the struct which the target points to
+func findStructKeyPrefix(val, target reflect.Value, parentKey string) (prefix string, found bool) {
+
+	val = val.Elem()
+	t := val.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+
+		tag, err := parseFieldTag(t.Field(i).Tag.Get(tagName))
+		if err != nil || tag.skip {
+			continue
+		}
+
+		keyName := t.Field(i).Name
+		if tag.name != "" {
+			keyName = tag.name
+		}
+
+		fieldVal := val.Field(i)
+
+		var ptr reflect.Value
+
+		switch {
+		case fieldVal.Kind() == reflect.Struct && fieldVal.CanAddr():
+			ptr = fieldVal.Addr()
+		case fieldVal.Kind() == reflect.Ptr && fieldVal.Type().Elem().Kind() == reflect.Struct && !fieldVal.IsNil():
+			ptr = fieldVal
+		default:
+			continue
+		}
+
+		key := parentKey + ":" + keyName
+
+		if ptr.Type() == target.Type() && ptr.Pointer() == target.Pointer() {
+			return key, true
+		}
+
+		if prefix, found = findStructKeyPrefix(ptr, target, key); found {
+			return
+		}
+	}
+
+	return
+}
@@ -0,0 +1,90 @@
+package redconf
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	tagName = "redconf"
+)
+
+var (
+	fieldTagOptions = map[string]bool{
+		"default":  true,
+		"required": true,
+		"min":      true,
+		"max":      true,
+		"oneof":    true,
+		"regexp":   true,
+		"encoding": true,
+		"sep":      true,
+		"map":      true,
+	}
+)
+
+// fieldTag is parsed from the tag like `redconf:"name,default=8080,required"`,
+// the value of option could contain comma, such as `redconf:",default=a,b,required"`,
+// the part which is not a known option will be appended to the previous option value
+type fieldTag struct {
+	name    string
+	skip    bool
+	options map[string]string
+}
+
+func parseFieldTag(tag string) (ft fieldTag, err error) {
+
+	ft.options = make(map[string]string)
+
+	if tag == "-" {
+		ft.skip = true
+		return
+	}
+
+	if tag == "" {
+		return
+	}
+
+	parts := strings.Split(tag, ",")
+
+	ft.name = strings.TrimSpace(parts[0])
+
+	lastOption := ""
+
+	for _, part := range parts[1:] {
+		optName := part
+		optValue := ""
+
+		if idx := strings.Index(part, "="); idx >= 0 {
+			optName = part[:idx]
+			optValue = part[idx+1:]
+		}
+
+		optName = strings.TrimSpace(optName)
+
+		if fieldTagOptions[optName] {
+			ft.options[optName] = optValue
+			lastOption = optName
+			continue
+		}
+
+		if lastOption == "" {
+			err = fmt.Errorf("redconf: unknown option of %s in tag `%s`", optName, tag)
+			return
+		}
+
+		ft.options[lastOption] += "," + part
+	}
+
+	return
+}
+
+func (p *fieldTag) option(name string) (value string, exist bool) {
+	value, exist = p.options[name]
+	return
+}
+
+func (p *fieldTag) hasOption(name string) bool {
+	_, exist := p.options[name]
+	return exist
+}
@@ -0,0 +1,45 @@
+package redconf
+
+import (
+	"testing"
+)
+
+func TestParseFieldTag(t *testing.T) {
+
+	cases := []struct {
+		tag     string
+		name    string
+		skip    bool
+		options map[string]string
+	}{
+		{tag: "", name: ""},
+		{tag: "-", skip: true},
+		{tag: "server_port", name: "server_port"},
+		{tag: "port,default=8080,required", name: "port", options: map[string]string{"default": "8080", "required": ""}},
+		{tag: ",default=a,b,c", name: "", options: map[string]string{"default": "a,b,c"}},
+		{tag: ",required,default=a,b", name: "", options: map[string]string{"default": "a,b", "required": ""}},
+	}
+
+	for _, c := range cases {
+		ft, err := parseFieldTag(c.tag)
+		if err != nil {
+			t.Errorf("parse tag `%s` failure: %s", c.tag, err)
+			continue
+		}
+
+		if ft.name != c.name || ft.skip != c.skip || len(ft.options) != len(c.options) {
+			t.Errorf("parse tag `%s` failed, got: %#v", c.tag, ft)
+			continue
+		}
+
+		for k, v := range c.options {
+			if optV, exist := ft.option(k); !exist || optV != v {
+				t.Errorf("parse tag `%s` failed, option %s excepted: %s, got: %s", c.tag, k, v, optV)
+			}
+		}
+	}
+
+	if _, err := parseFieldTag("port,unknown=1"); err == nil {
+		t.Error("the unknown option should be failed")
+	}
+}
@@ -0,0 +1,141 @@
+package redconf
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Validator could be implemented by the watched struct, the update will be
+// rejected and the previous value retained while Validate returned error
+type Validator interface {
+	Validate() error
+}
+
+// fieldValidator check the constraints in tag like `redconf:"port,min=1,max=65535"`,
+// min and max compare the number value, or the length of string, slice, array and map,
+// oneof accept the values separated by space or comma, such as `redconf:"level,oneof=debug info"`
+type fieldValidator struct {
+	min    *float64
+	max    *float64
+	oneof  []string
+	regexp *regexp.Regexp
+}
+
+func newFieldValidator(tag fieldTag) (validator *fieldValidator, err error) {
+
+	v := &fieldValidator{}
+	hasConstraint := false
+
+	if strMin, exist := tag.option("min"); exist {
+		var min float64
+		if min, err = strconv.ParseFloat(strMin, 64); err != nil {
+			err = fmt.Errorf("redconf: the min of %s is not a number", strMin)
+			return
+		}
+		v.min = &min
+		hasConstraint = true
+	}
+
+	if strMax, exist := tag.option("max"); exist {
+		var max float64
+		if max, err = strconv.ParseFloat(strMax, 64); err != nil {
+			err = fmt.Errorf("redconf: the max of %s is not a number", strMax)
+			return
+		}
+		v.max = &max
+		hasConstraint = true
+	}
+
+	if strOneof, exist := tag.option("oneof"); exist {
+		v.oneof = strings.FieldsFunc(strOneof, func(r rune) bool {
+			return r == ',' || unicode.IsSpace(r)
+		})
+		hasConstraint = true
+	}
+
+	if strRegexp, exist := tag.option("regexp"); exist {
+		if v.regexp, err = regexp.Compile(strRegexp); err != nil {
+			return
+		}
+		hasConstraint = true
+	}
+
+	if hasConstraint {
+		validator = v
+	}
+
+	return
+}
+
+func (p *fieldValidator) validate(value interface{}) (err error) {
+
+	val := reflect.ValueOf(value)
+
+	for val.Kind() == reflect.Ptr {
+		if val.IsNil() {
+			return
+		}
+		val = val.Elem()
+	}
+
+	if !val.IsValid() {
+		return
+	}
+
+	if p.min != nil || p.max != nil {
+		var num float64
+		var what string
+
+		switch val.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			num, what = float64(val.Int()), "value"
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			num, what = float64(val.Uint()), "value"
+		case reflect.Float32, reflect.Float64:
+			num, what = val.Float(), "value"
+		case reflect.String, reflect.Slice, reflect.Array, reflect.Map:
+			num, what = float64(val.Len()), "length"
+		default:
+			err = fmt.Errorf("redconf: min and max could not apply to kind of %s", val.Kind())
+			return
+		}
+
+		if p.min != nil && num < *p.min {
+			err = fmt.Errorf("redconf: the %s %v is less than min %v", what, num, *p.min)
+			return
+		}
+
+		if p.max != nil && num > *p.max {
+			err = fmt.Errorf("redconf: the %s %v is greater than max %v", what, num, *p.max)
+			return
+		}
+	}
+
+	strV := fmt.Sprintf("%v", val.Interface())
+
+	if len(p.oneof) > 0 {
+		found := false
+		for _, one := range p.oneof {
+			if one == strV {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			err = fmt.Errorf("redconf: the value %s is not one of [%s]", strV, strings.Join(p.oneof, " "))
+			return
+		}
+	}
+
+	if p.regexp != nil && !p.regexp.MatchString(strV) {
+		err = fmt.Errorf("redconf: the value %s is not match regexp %s", strV, p.regexp.String())
+		return
+	}
+
+	return
+}
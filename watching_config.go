@@ -2,9 +2,11 @@ package redconf
 
 import (
 	"errors"
+	"fmt"
 	"reflect"
 	"strings"
 	"sync"
+	"sync/atomic"
 )
 
 type WatchingConfig struct {
@@ -13,6 +15,12 @@ type WatchingConfig struct {
 
 	fields   []*Field
 	initOnce sync.Once
+
+	// in copy on write mode, the value is never changed after synced,
+	// the updates are applied to a copy and swapped to the snapshot
+	copyOnWrite bool
+	snapshot    atomic.Value
+	valLock     sync.Mutex
 }
 
 func NewWatchingConfig(v interface{}, name ...string) (wConf *WatchingConfig, err error) {
@@ -69,6 +77,20 @@ func (p *WatchingConfig) Name() string {
 	return p.name
 }
 
+// Snapshot return the pointer to the current config, it is the watching value
+// unless the config is in copy on write mode, the snapshot should not be modified
+func (p *WatchingConfig) Snapshot() interface{} {
+	return p.root().Interface()
+}
+
+func (p *WatchingConfig) root() reflect.Value {
+	if v := p.snapshot.Load(); v != nil {
+		return reflect.ValueOf(v)
+	}
+
+	return reflect.ValueOf(p.value)
+}
+
 func (p *WatchingConfig) Fields() []Field {
 	var fs []Field
 
@@ -78,6 +100,37 @@ func (p *WatchingConfig) Fields() []Field {
 	return fs
 }
 
+// copyValue return the pointer of a shallow copy of the current config
+func (p *WatchingConfig) copyValue() reflect.Value {
+	val := p.root()
+
+	cp := reflect.New(val.Type().Elem())
+	cp.Elem().Set(val.Elem())
+
+	return cp
+}
+
+// apply set the changes in place, or set them to a copy of the config and swap
+// the snapshot once while the config is in copy on write mode
+func (p *WatchingConfig) apply(changes []*fieldChange) {
+	p.valLock.Lock()
+	defer p.valLock.Unlock()
+
+	if p.copyOnWrite {
+		cp := p.copyValue()
+		for _, change := range changes {
+			change.field.setOn(cp, change.event.AfterValue, true)
+		}
+		p.snapshot.Store(cp.Interface())
+		return
+	}
+
+	root := p.root()
+	for _, change := range changes {
+		change.field.setOn(root, change.event.AfterValue, false)
+	}
+}
+
 func (p *WatchingConfig) initFields(v interface{}) (fields []*Field, err error) {
 	val := reflect.ValueOf(v)
 
@@ -86,18 +139,20 @@ func (p *WatchingConfig) initFields(v interface{}) (fields []*Field, err error)
 		return
 	}
 
-	if fields, err = p.getStructFields([]string{}, val, 0); err != nil {
+	if fields, err = p.getStructFields([]string{}, []string{}, val, 0); err != nil {
 		return
 	}
 
 	for _, field := range fields {
-		field.topVal = v
+		field.conf = p
 	}
 
 	return
 }
 
-func (p *WatchingConfig) getStructFields(parents []string, val reflect.Value, level int) (fields []*Field, err error) {
+// getStructFields walk the struct fields, the parents is the names of parent fields for reflection,
+// and the keyParents is the names in the key which could be renamed by tag
+func (p *WatchingConfig) getStructFields(parents, keyParents []string, val reflect.Value, level int) (fields []*Field, err error) {
 
 	var t reflect.Type
 	var isSupport bool
@@ -114,14 +169,33 @@ func (p *WatchingConfig) getStructFields(parents []string, val reflect.Value, le
 	var tmpFields []*Field
 
 	for i := 0; i < t.NumField(); i++ {
+
+		var tag fieldTag
+		if tag, err = parseFieldTag(t.Field(i).Tag.Get(tagName)); err != nil {
+			err = fmt.Errorf("redconf: parse tag of field %s.%s failure, %s", t.Name(), t.Field(i).Name, err)
+			return
+		}
+
+		if tag.skip {
+			continue
+		}
+
+		keyName := t.Field(i).Name
+		if tag.name != "" {
+			keyName = tag.name
+		}
+
 		vKind := val.Field(i).Kind()
 
 		if vKind == reflect.Ptr {
 			vKind = val.Field(i).Type().Elem().Kind()
 		}
 
-		switch vKind {
-		case reflect.Struct:
+		// the struct like time.Time is a value but not the nested config
+		typedValue := isTypedValue(t.Field(i).Type)
+
+		switch {
+		case vKind == reflect.Struct && !typedValue:
 			{
 				var tFields []*Field
 				var nextVal reflect.Value
@@ -136,30 +210,41 @@ func (p *WatchingConfig) getStructFields(parents []string, val reflect.Value, le
 					val.Field(i).Set(nextVal)
 				}
 
-				if tFields, err = p.getStructFields(append(parents, t.Field(i).Name), nextVal, level+1); err != nil {
+				if tFields, err = p.getStructFields(appendPath(parents, t.Field(i).Name), appendPath(keyParents, keyName), nextVal, level+1); err != nil {
 					return
 				}
 				tmpFields = append(tmpFields, tFields...)
 			}
-		case reflect.Array, reflect.Slice,
-			reflect.Bool, reflect.Map,
-			reflect.Float32, reflect.Float64,
-			reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
-			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
-			reflect.String:
+		case typedValue || isValueKind(vKind):
 
 			tmpStrs := []string{p.name}
-			tmpStrs = append(tmpStrs, parents...)
-			tmpStrs = append(tmpStrs, t.Field(i).Name)
+			tmpStrs = append(tmpStrs, keyParents...)
+			tmpStrs = append(tmpStrs, keyName)
 
 			field := &Field{
 				name:        t.Field(i).Name,
 				parents:     parents,
 				level:       level,
 				structField: t.Field(i),
+				tag:         tag,
 				str:         strings.Join(tmpStrs, ":"),
 			}
 
+			if field.convOptions, err = newConvOptions(tag); err != nil {
+				err = fmt.Errorf("redconf: parse tag of field %s.%s failure, %s", t.Name(), t.Field(i).Name, err)
+				return
+			}
+
+			if field.convOptions.mapMode != "" && !isEntriesMap(t.Field(i).Type) {
+				err = fmt.Errorf("redconf: the field %s.%s with map=%s should be map[string]T", t.Name(), t.Field(i).Name, field.convOptions.mapMode)
+				return
+			}
+
+			if field.validator, err = newFieldValidator(tag); err != nil {
+				err = fmt.Errorf("redconf: parse tag of field %s.%s failure, %s", t.Name(), t.Field(i).Name, err)
+				return
+			}
+
 			tmpFields = append(tmpFields, field)
 		}
 	}
@@ -168,6 +253,31 @@ func (p *WatchingConfig) getStructFields(parents []string, val reflect.Value, le
 	return
 }
 
+func isValueKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Array, reflect.Slice,
+		reflect.Bool, reflect.Map,
+		reflect.Float32, reflect.Float64,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.String:
+		return true
+	}
+
+	return false
+}
+
+// isEntriesMap check the map could be stored by entries, the key should be kind of string
+func isEntriesMap(typ reflect.Type) bool {
+	return typ.Kind() == reflect.Map && typ.Key().Kind() == reflect.String
+}
+
+func appendPath(path []string, name string) []string {
+	newPath := make([]string, 0, len(path)+1)
+	newPath = append(newPath, path...)
+	return append(newPath, name)
+}
+
 func getRelValueAndType(val reflect.Value) (retV reflect.Value, retType reflect.Type, isSupport bool) {
 
 	isSupport = true